@@ -1,24 +1,28 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"example.com/practice-rest/internal/models"
+	"example.com/practice-rest/internal/passwords"
+	"example.com/practice-rest/internal/sso"
 	"example.com/practice-rest/internal/validator"
 	"example.com/practice-rest/pkg/lib"
+	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"github.com/samber/lo"
-	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"strconv"
+	"time"
 )
 
-func (app *application) getPosts(res http.ResponseWriter, _ *http.Request) {
-	posts, err := app.post.Latest()
+func (app *application) getPosts(res http.ResponseWriter, req *http.Request) {
+	posts, err := app.post.Latest(req.Context())
 
 	if lo.IsNotEmpty(err) {
-		app.errorLog.Println(err)
-		lib.WriteJSON(res, http.StatusInternalServerError, lib.InternalServerError)
+		app.internalError(res, req, err)
 		return
 	}
 
@@ -35,19 +39,17 @@ func (app *application) getSinglePost(res http.ResponseWriter, req *http.Request
 	params := httprouter.ParamsFromContext(req.Context())
 	id, err := strconv.Atoi(params.ByName("id"))
 	if lo.IsNotEmpty(err) {
-		app.errorLog.Println(err)
-		lib.WriteJSON(res, http.StatusInternalServerError, lib.InternalServerError)
+		app.internalError(res, req, err)
 		return
 	}
 
-	post, err := app.post.Get(id)
+	post, err := app.post.Get(req.Context(), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			lib.WriteJSON(res, http.StatusNotFound, lib.Response{Status: false, Result: nil, Message: "Post Not Found"})
 			return
 		}
-		app.errorLog.Println(err)
-		lib.WriteJSON(res, http.StatusInternalServerError, lib.InternalServerError)
+		app.internalError(res, req, err)
 		return
 
 	}
@@ -61,8 +63,7 @@ func (app *application) createPost(res http.ResponseWriter, req *http.Request) {
 		validator.Validator
 	}
 
-	// Limit the size of the request body to 4KB
-	req.Body = http.MaxBytesReader(res, req.Body, 4096)
+	req.Body = http.MaxBytesReader(res, req.Body, app.maxBodyBytes)
 	body := new(PostDTO)
 	json.NewDecoder(req.Body).Decode(&body)
 
@@ -76,19 +77,76 @@ func (app *application) createPost(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	id, err := app.post.Insert(body.Title, body.Content)
+	id, err := app.post.Insert(req.Context(), app.authenticatedUserID(req), body.Title, body.Content)
 	if lo.IsNotEmpty(err) {
-		app.errorLog.Println(err)
-		lib.WriteJSON(res, http.StatusInternalServerError, lib.InternalServerError)
+		app.internalError(res, req, err)
 		return
 	}
 
 	app.sessionManger.Put(req.Context(), "flash", "Post Created Successfully")
 
-	app.infoLog.Println(body)
+	app.logger.InfoContext(req.Context(), "Post Created", "id", id)
 	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: id, Message: "New Post Created"})
 }
 
+// deletePost moves one of the user's posts to the trash, from where it can be
+// restored until the retention window is over.
+func (app *application) deletePost(res http.ResponseWriter, req *http.Request) {
+	params := httprouter.ParamsFromContext(req.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if lo.IsNotEmpty(err) {
+		lib.WriteJSON(res, http.StatusNotFound, lib.Response{Status: false, Result: nil, Message: "Post Not Found"})
+		return
+	}
+
+	err = app.post.Trash(req.Context(), id, app.authenticatedUserID(req))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			lib.WriteJSON(res, http.StatusNotFound, lib.Response{Status: false, Result: nil, Message: "Post Not Found"})
+			return
+		}
+		app.internalError(res, req, err)
+		return
+	}
+
+	app.logger.InfoContext(req.Context(), "Post Moved To Trash", "id", id)
+	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: id, Message: "Post Moved To Trash"})
+}
+
+// restorePost takes one of the user's posts out of the trash.
+func (app *application) restorePost(res http.ResponseWriter, req *http.Request) {
+	params := httprouter.ParamsFromContext(req.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if lo.IsNotEmpty(err) {
+		lib.WriteJSON(res, http.StatusNotFound, lib.Response{Status: false, Result: nil, Message: "Post Not Found"})
+		return
+	}
+
+	err = app.post.Restore(req.Context(), id, app.authenticatedUserID(req))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			lib.WriteJSON(res, http.StatusNotFound, lib.Response{Status: false, Result: nil, Message: "Post Not Found"})
+			return
+		}
+		app.internalError(res, req, err)
+		return
+	}
+
+	app.logger.InfoContext(req.Context(), "Post Restored", "id", id)
+	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: id, Message: "Post Restored"})
+}
+
+// getTrash lists the user's posts in the trash, most recently deleted first.
+func (app *application) getTrash(res http.ResponseWriter, req *http.Request) {
+	posts, err := app.post.Trashed(req.Context(), app.authenticatedUserID(req))
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return
+	}
+
+	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: lo.Ternary(posts == nil, []*models.Post{}, posts), Message: "Trash Found"})
+}
+
 func (app *application) userSignup(res http.ResponseWriter, req *http.Request) {
 	type UserSignupDTO struct {
 		Name     string `json:"name" validate:"required"`
@@ -98,6 +156,7 @@ func (app *application) userSignup(res http.ResponseWriter, req *http.Request) {
 	}
 
 	body := new(UserSignupDTO)
+	req.Body = http.MaxBytesReader(res, req.Body, app.maxBodyBytes)
 	json.NewDecoder(req.Body).Decode(&body)
 
 	body.CheckField(validator.NotEmpty(body.Name), "name", "name cannot be blank")
@@ -108,27 +167,28 @@ func (app *application) userSignup(res http.ResponseWriter, req *http.Request) {
 
 	body.CheckField(validator.NotEmpty(body.Password), "password", "password cannot be blank")
 	body.CheckField(validator.MinChars(body.Password, 8), "password", "password must be at least 8 characters")
+	body.CheckField(!passwords.TooLong(body.Password), "password", fmt.Sprintf("password must be at most %d bytes", passwords.MaxBytes))
+	body.CheckField(!passwords.IsCommon(body.Password), "password", "password is too common")
 
 	if !body.Valid() {
 		lib.WriteJSON(res, http.StatusBadRequest, lib.Response{Status: false, Result: body.Errors, Message: "Validation Error"})
 		return
 	}
 
-	hashedPass, errHashing := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
-	id, errInserting := app.user.Insert(body.Name, body.Email, string(hashedPass))
+	hashedPass, errHashing := app.hasher.Hash(body.Password)
+	id, errInserting := app.user.Insert(req.Context(), body.Name, body.Email, hashedPass)
 
 	if err := errors.Join(errHashing, errInserting); lo.IsNotEmpty(err) {
 		if errors.Is(err, models.ErrDuplicateEmail) {
-			app.infoLog.Println("Duplicate Email")
+			app.logger.InfoContext(req.Context(), "Duplicate Email", "email", body.Email)
 			lib.WriteJSON(res, http.StatusBadRequest, lib.Response{Status: false, Result: nil, Message: "Email Already Exists"})
 			return
 		}
-		app.errorLog.Println(err)
-		lib.WriteJSON(res, http.StatusInternalServerError, lib.InternalServerError)
+		app.internalError(res, req, err)
 		return
 	}
 
-	app.infoLog.Println("User Signup Successfully", id, body.Email)
+	app.logger.InfoContext(req.Context(), "User Signup Successfully", "id", id, "email", body.Email)
 	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: body.Email, Message: "User Signup Successfully"})
 }
 
@@ -140,6 +200,7 @@ func (app *application) userLogin(res http.ResponseWriter, req *http.Request) {
 	}
 
 	body := new(UserLoginDTO)
+	req.Body = http.MaxBytesReader(res, req.Body, app.maxBodyBytes)
 	json.NewDecoder(req.Body).Decode(&body)
 
 	body.CheckField(validator.NotEmpty(body.Email), "email", "email cannot be blank")
@@ -153,35 +214,31 @@ func (app *application) userLogin(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	id, err := app.user.Authenticate(body.Email, body.Password)
-	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			app.infoLog.Println("Invalid Credentials")
-			lib.WriteJSON(res, http.StatusUnauthorized, lib.Response{Status: false, Result: nil, Message: "Invalid Credentials"})
-			return
-		}
-		app.errorLog.Println(err)
-		lib.WriteJSON(res, http.StatusInternalServerError, lib.InternalServerError)
+	id, ok := app.authenticate(res, req, body.Email, body.Password)
+	if !ok {
 		return
 	}
 
-	err = app.sessionManger.RenewToken(req.Context())
-	if err != nil {
-		app.errorLog.Println(err)
-		lib.WriteJSON(res, http.StatusInternalServerError, lib.InternalServerError)
+	err := app.startSession(req, id)
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
 		return
 	}
 
-	app.sessionManger.Put(req.Context(), "authenticatedUserID", id)
-	app.infoLog.Println("User Login Successfully", id, body.Email)
+	app.logger.InfoContext(req.Context(), "User Login Successfully", "id", id, "email", body.Email)
 	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: body.Email, Message: "User Login Successfully"})
 }
 
 func (app *application) userLogout(res http.ResponseWriter, req *http.Request) {
-	err := app.sessionManger.Destroy(req.Context())
+	err := app.session.DeleteByToken(req.Context(), app.sessionManger.Token(req.Context()))
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return
+	}
+
+	err = app.sessionManger.Destroy(req.Context())
 	if lo.IsNotEmpty(err) {
-		app.errorLog.Println(err)
-		lib.WriteJSON(res, http.StatusInternalServerError, lib.InternalServerError)
+		app.internalError(res, req, err)
 		return
 	}
 
@@ -189,7 +246,344 @@ func (app *application) userLogout(res http.ResponseWriter, req *http.Request) {
 	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: nil, Message: "User Logout Successfully"})
 }
 
+func (app *application) ssoLogin(res http.ResponseWriter, req *http.Request) {
+	params := httprouter.ParamsFromContext(req.Context())
+	provider, err := app.sso.Get(params.ByName("provider"))
+	if lo.IsNotEmpty(err) {
+		lib.WriteJSON(res, http.StatusNotFound, lib.Response{Status: false, Result: nil, Message: "Provider Not Found"})
+		return
+	}
+
+	url, flow, err := provider.Begin()
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return
+	}
+
+	// The flow is checked against the callback, so it has to stay server side
+	app.sessionManger.Put(req.Context(), "ssoProvider", provider.Name())
+	app.sessionManger.Put(req.Context(), "ssoState", flow.State)
+	app.sessionManger.Put(req.Context(), "ssoNonce", flow.Nonce)
+	app.sessionManger.Put(req.Context(), "ssoVerifier", flow.Verifier)
+
+	http.Redirect(res, req, url, http.StatusFound)
+}
+
+func (app *application) ssoCallback(res http.ResponseWriter, req *http.Request) {
+	params := httprouter.ParamsFromContext(req.Context())
+	provider, err := app.sso.Get(params.ByName("provider"))
+	if lo.IsNotEmpty(err) {
+		lib.WriteJSON(res, http.StatusNotFound, lib.Response{Status: false, Result: nil, Message: "Provider Not Found"})
+		return
+	}
+
+	// Pop rather than Get so a flow can only be completed once
+	ctx := req.Context()
+	started := app.sessionManger.PopString(ctx, "ssoProvider")
+	flow := sso.Flow{
+		State:    app.sessionManger.PopString(ctx, "ssoState"),
+		Nonce:    app.sessionManger.PopString(ctx, "ssoNonce"),
+		Verifier: app.sessionManger.PopString(ctx, "ssoVerifier"),
+	}
+	if started != provider.Name() {
+		lib.WriteJSON(res, http.StatusBadRequest, lib.Response{Status: false, Result: nil, Message: "Invalid Login State"})
+		return
+	}
+
+	query := req.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		app.logger.InfoContext(req.Context(), "Provider Login Failed", "provider", provider.Name(), "reason", reason)
+		lib.WriteJSON(res, http.StatusUnauthorized, lib.Response{Status: false, Result: reason, Message: "Login Failed"})
+		return
+	}
+
+	identity, err := provider.Finish(ctx, flow, query.Get("state"), query.Get("code"))
+	if err != nil {
+		if errors.Is(err, sso.ErrInvalidState) || errors.Is(err, sso.ErrInvalidNonce) {
+			lib.WriteJSON(res, http.StatusBadRequest, lib.Response{Status: false, Result: nil, Message: "Invalid Login State"})
+			return
+		}
+		app.logger.InfoContext(req.Context(), "Provider Login Failed", "provider", provider.Name(), "error", err)
+		lib.WriteJSON(res, http.StatusUnauthorized, lib.Response{Status: false, Result: nil, Message: "Login Failed"})
+		return
+	}
+
+	id, err := app.linkIdentity(req.Context(), identity)
+	if err != nil {
+		if errors.Is(err, sso.ErrUnverifiedEmail) {
+			lib.WriteJSON(res, http.StatusForbidden, lib.Response{Status: false, Result: nil, Message: "Email Not Verified"})
+			return
+		}
+		app.internalError(res, req, err)
+		return
+	}
+
+	err = app.startSession(req, id)
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return
+	}
+
+	app.logger.InfoContext(req.Context(), "User Login Successfully", "id", id, "email", identity.Email, "provider", provider.Name())
+	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: identity.Email, Message: "User Login Successfully"})
+}
+
+func (app *application) listSessions(res http.ResponseWriter, req *http.Request) {
+	sessions, err := app.session.ListByUser(req.Context(), app.authenticatedUserID(req))
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return
+	}
+
+	current := app.sessionManger.Token(req.Context())
+	active := make([]*models.Session, 0, len(sessions))
+	for _, session := range sessions {
+		// Sessions that expired in the store are pruned from the index as we go
+		_, found, err := app.sessionManger.Store.Find(session.Token)
+		if lo.IsNotEmpty(err) {
+			app.internalError(res, req, err)
+			return
+		}
+		if !found {
+			if err = app.session.Delete(req.Context(), session.ID); err != nil {
+				app.logger.ErrorContext(req.Context(), "Internal Error", "error", err)
+			}
+			continue
+		}
+
+		session.Current = session.Token == current
+		active = append(active, session)
+	}
+
+	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: active, Message: "Sessions Found"})
+}
+
+func (app *application) revokeSession(res http.ResponseWriter, req *http.Request) {
+	params := httprouter.ParamsFromContext(req.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if lo.IsNotEmpty(err) {
+		lib.WriteJSON(res, http.StatusNotFound, lib.Response{Status: false, Result: nil, Message: "Session Not Found"})
+		return
+	}
+
+	session, err := app.session.Get(req.Context(), id, app.authenticatedUserID(req))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			lib.WriteJSON(res, http.StatusNotFound, lib.Response{Status: false, Result: nil, Message: "Session Not Found"})
+			return
+		}
+		app.internalError(res, req, err)
+		return
+	}
+
+	if err = app.revoke(req, session); err != nil {
+		app.internalError(res, req, err)
+		return
+	}
+
+	app.logger.InfoContext(req.Context(), "Session Revoked", "session_id", session.ID)
+	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: nil, Message: "Session Revoked"})
+}
+
+// revokeAllSessions logs the user out everywhere, including the session the
+// request was made with.
+func (app *application) revokeAllSessions(res http.ResponseWriter, req *http.Request) {
+	userID := app.authenticatedUserID(req)
+
+	sessions, err := app.session.ListByUser(req.Context(), userID)
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return
+	}
+
+	for _, session := range sessions {
+		if err = app.revoke(req, session); err != nil {
+			app.internalError(res, req, err)
+			return
+		}
+	}
+
+	app.logger.InfoContext(req.Context(), "All Sessions Revoked", "count", len(sessions))
+	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: len(sessions), Message: "All Sessions Revoked"})
+}
+
+// deleteAccount schedules the user's account for deletion once the grace period
+// is over and logs them out everywhere. Logging in again before then cancels it.
+func (app *application) deleteAccount(res http.ResponseWriter, req *http.Request) {
+	type DeleteAccountDTO struct {
+		Password     string `json:"password"`
+		ConfirmEmail string `json:"confirm_email"`
+		validator.Validator
+	}
+
+	body := new(DeleteAccountDTO)
+	req.Body = http.MaxBytesReader(res, req.Body, app.maxBodyBytes)
+	json.NewDecoder(req.Body).Decode(&body)
+
+	user, err := app.user.Get(req.Context(), app.authenticatedUserID(req))
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return
+	}
+
+	// Accounts created through an identity provider have no password to
+	// confirm with, they have to type their email instead
+	if user.HashedPassword == "" {
+		body.CheckField(body.ConfirmEmail == user.Email, "confirm_email", "confirm_email must match the account email")
+	} else {
+		body.CheckField(validator.NotEmpty(body.Password), "password", "password cannot be blank")
+	}
+
+	if !body.Valid() {
+		lib.WriteJSON(res, http.StatusBadRequest, lib.Response{Status: false, Result: body.Errors, Message: "Validation Error"})
+		return
+	}
+
+	// Same lockout as logging in, or a stolen session could be used to guess
+	// the password
+	if user.HashedPassword != "" {
+		if _, ok := app.authenticate(res, req, user.Email, body.Password); !ok {
+			return
+		}
+	}
+
+	due := time.Now().Add(app.deletionGrace).UTC()
+	if err = app.user.ScheduleDeletion(req.Context(), user.ID, due); err != nil {
+		app.internalError(res, req, err)
+		return
+	}
+
+	sessions, err := app.session.ListByUser(req.Context(), user.ID)
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return
+	}
+	for _, session := range sessions {
+		if err = app.revoke(req, session); err != nil {
+			app.internalError(res, req, err)
+			return
+		}
+	}
+
+	app.logger.InfoContext(req.Context(), "Account Deletion Scheduled", "due", due)
+	lib.WriteJSON(res, http.StatusAccepted, lib.Response{Status: true, Result: due, Message: "Account Deletion Scheduled"})
+}
+
+// exportAccount sends a ZIP of everything stored about the user, one JSON file
+// per kind of record. Posts in the trash are still stored, so they are
+// exported too.
+func (app *application) exportAccount(res http.ResponseWriter, req *http.Request) {
+	userID := app.authenticatedUserID(req)
+
+	user, errUser := app.user.Get(req.Context(), userID)
+	posts, errPosts := app.post.ByUser(req.Context(), userID)
+	trash, errTrash := app.post.Trashed(req.Context(), userID)
+	sessions, errSessions := app.session.ListByUser(req.Context(), userID)
+
+	if err := errors.Join(errUser, errPosts, errTrash, errSessions); lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", user},
+		{"posts.json", lo.Ternary(posts == nil, []*models.Post{}, posts)},
+		{"trash.json", lo.Ternary(trash == nil, []*models.Post{}, trash)},
+		{"sessions.json", lo.Ternary(sessions == nil, []*models.Session{}, sessions)},
+	}
+
+	// Build the archive up front so a failure can still be reported as JSON
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err == nil {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(file.data)
+		}
+		if err != nil {
+			app.internalError(res, req, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		app.internalError(res, req, err)
+		return
+	}
+
+	app.logger.InfoContext(req.Context(), "Account Exported")
+	res.Header().Set("Content-Type", "application/zip")
+	res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%d.zip"`, userID))
+	res.Header().Set("Content-Length", strconv.Itoa(archive.Len()))
+	res.WriteHeader(http.StatusOK)
+	archive.WriteTo(res)
+}
+
+// purgeTrash hard deletes the posts that have been in the trash for longer
+// than the retention window, or than the older_than duration if one is given,
+// without waiting for the deletion worker. It is only served on the admin
+// listener, behind requireAdmin.
+func (app *application) purgeTrash(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		lib.WriteJSON(res, http.StatusMethodNotAllowed, lib.MethodNotAllowed)
+		return
+	}
+
+	olderThan := app.trashRetention
+	if value := req.URL.Query().Get("older_than"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			lib.WriteJSON(res, http.StatusBadRequest, lib.Response{Status: false, Result: map[string]string{"older_than": "older_than must be a duration like 72h"}, Message: "Validation Error"})
+			return
+		}
+		olderThan = d
+	}
+
+	count, err := app.post.PurgeTrashed(req.Context(), time.Now().Add(-olderThan))
+	if err != nil {
+		app.internalError(res, req, err)
+		return
+	}
+
+	app.logger.InfoContext(req.Context(), "Trash Purged", "posts", count)
+	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: map[string]int{"posts": count}, Message: "Trash Purged"})
+}
+
+// getCSRFToken hands out the token that state-changing requests made with the
+// session cookie have to send back in the X-CSRF-Token header.
+func (app *application) getCSRFToken(res http.ResponseWriter, req *http.Request) {
+	token, err := app.csrfToken(req)
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return
+	}
+
+	res.Header().Set("Cache-Control", "no-store")
+	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: token, Message: "CSRF Token"})
+}
+
+// healthCheck is the liveness probe: answering at all means the process is up.
+// Dependencies are deliberately not checked here, a restart wouldn't fix them.
 func healthCheck(res http.ResponseWriter, req *http.Request) {
 	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: "Healthy", Message: "Hello World"})
 	return
 }
+
+// readinessCheck tells the orchestrator whether to route traffic here.
+func (app *application) readinessCheck(res http.ResponseWriter, req *http.Request) {
+	report := app.health.Run(req.Context())
+
+	res.Header().Set("Cache-Control", "no-store")
+	if !report.Ready {
+		app.logger.WarnContext(req.Context(), "Not Ready", "checks", report.Checks, "draining", report.Draining)
+		lib.WriteJSON(res, http.StatusServiceUnavailable, lib.Response{Status: false, Result: report, Message: "Not Ready"})
+		return
+	}
+
+	lib.WriteJSON(res, http.StatusOK, lib.Response{Status: true, Result: report, Message: "Ready"})
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"example.com/practice-rest/internal/cors"
+	"example.com/practice-rest/internal/health"
+	"example.com/practice-rest/internal/lockout"
+	"example.com/practice-rest/internal/metrics"
+	"example.com/practice-rest/internal/models"
+	"example.com/practice-rest/internal/passwords"
+	"example.com/practice-rest/internal/ratelimit"
+	"example.com/practice-rest/pkg/config"
+	"example.com/practice-rest/pkg/lib"
+	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer is the API on the memory store, with a clock the memory
+// repositories read instead of time.Now.
+type testServer struct {
+	*httptest.Server
+	app *application
+	now time.Time
+}
+
+func newTestServer(t *testing.T) *testServer {
+	hasher := &passwords.Hasher{Current: passwords.Bcrypt{Cost: 4}}
+	store, err := openStorage(config.Database{DSN: "memory"}, hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	// memstore's StopCleanup races with the goroutine it stops when that has
+	// only just started, so the sessions Close stops have none
+	store.sessions = memstore.NewWithCleanupInterval(0)
+
+	ts := &testServer{now: time.Now()}
+	clock := func() time.Time { return ts.now }
+	store.post.(*models.MemoryPostRepository).Now = clock
+	store.user.(*models.MemoryUserRepository).Now = clock
+	store.session.(*models.MemorySessionRepository).Now = clock
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sessionManger := scs.New()
+	sessionManger.Store = store.sessions
+	sessionManger.Cookie.Secure = true
+	sessionManger.Cookie.HttpOnly = true
+	sessionManger.Cookie.SameSite = http.SameSiteLaxMode
+
+	policy := lockout.Policy{Threshold: 5, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour}
+	ts.app = &application{
+		logger:         logger,
+		post:           store.post,
+		user:           store.user,
+		session:        store.session,
+		sessionManger:  sessionManger,
+		loginGuard:     &lockout.Guard{Store: lockout.NewMemoryStore(logger), Account: policy, IP: policy},
+		identity:       store.identity,
+		unitOfWork:     store.unitOfWork,
+		deletionGrace:  24 * time.Hour,
+		deletionPolicy: deletionAnonymise,
+		hasher:         hasher,
+		cors:           &cors.Policy{},
+		limiter:        &ratelimit.Limiter{Store: ratelimit.NewMemoryStore()},
+		rateLimits: map[string]ratelimit.Limit{
+			rateLimitDefault: ratelimit.PerMinute(1000),
+			rateLimitAuth:    ratelimit.PerMinute(1000),
+			rateLimitUser:    ratelimit.PerMinute(1000),
+		},
+		metrics:      metrics.New(nil, "test"),
+		health:       &health.Checker{Timeout: time.Second},
+		maxBodyBytes: 1 << 20,
+	}
+
+	ts.Server = httptest.NewTLSServer(ts.app.routes())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// testClient keeps the session cookie and the CSRF token of one browser.
+type testClient struct {
+	t      *testing.T
+	ts     *testServer
+	client *http.Client
+	csrf   string
+	// header is the header of the last response
+	header http.Header
+}
+
+func (ts *testServer) newClient(t *testing.T) *testClient {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ts.Client() is shared, every testClient gets its own cookies
+	client := &http.Client{Transport: ts.Client().Transport, Jar: jar}
+	return &testClient{t: t, ts: ts, client: client}
+}
+
+// do sends body as JSON with the CSRF token and returns the status and the
+// raw response body.
+func (c *testClient) do(method, path string, body any, header http.Header) (int, []byte) {
+	c.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.ts.URL+path, reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.csrf != "" {
+		req.Header.Set("X-CSRF-Token", c.csrf)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	c.header = res.Header
+
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res.StatusCode, raw
+}
+
+// call is do for the JSON endpoints, it fails the test unless the status is
+// want.
+func (c *testClient) call(method, path string, body any, want int) lib.Response {
+	c.t.Helper()
+
+	status, raw := c.do(method, path, body, nil)
+	var response lib.Response
+	json.Unmarshal(raw, &response)
+	if status != want {
+		c.t.Fatalf("%s %s = %d %s, want %d", method, path, status, raw, want)
+	}
+	return response
+}
+
+// fetchCSRF picks up the token of the current session, which login replaces.
+func (c *testClient) fetchCSRF() {
+	c.t.Helper()
+	c.csrf = ""
+	c.csrf = c.call(http.MethodGet, "/csrf", nil, http.StatusOK).Result.(string)
+}
+
+func (c *testClient) signupAndLogin(email string) {
+	c.t.Helper()
+	c.fetchCSRF()
+	c.call(http.MethodPost, "/user/signup", map[string]string{"name": "Amal", "email": email, "password": "a long secret"}, http.StatusOK)
+	c.login(email)
+}
+
+func (c *testClient) login(email string) {
+	c.t.Helper()
+	c.fetchCSRF()
+	c.call(http.MethodPost, "/user/login", map[string]string{"email": email, "password": "a long secret"}, http.StatusOK)
+	c.fetchCSRF()
+}
+
+func TestCreateAndGetPost(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newClient(t)
+
+	c.call(http.MethodPost, "/post", map[string]string{"title": "t", "content": "c"}, http.StatusForbidden)
+
+	c.signupAndLogin("amal@example.com")
+	c.call(http.MethodPost, "/post", map[string]string{"title": "", "content": "c"}, http.StatusBadRequest)
+	c.call(http.MethodPost, "/post", map[string]string{"title": "Hello", "content": "World"}, http.StatusOK)
+
+	post := c.call(http.MethodGet, "/post/1", nil, http.StatusOK).Result.(map[string]any)
+	if post["title"] != "Hello" {
+		t.Errorf("GET /post/1 = %v, want the new post", post)
+	}
+	if posts := c.call(http.MethodGet, "/post", nil, http.StatusOK).Result.([]any); len(posts) != 1 {
+		t.Errorf("GET /post = %v, want the new post", posts)
+	}
+
+	// Posts expire 30 days after they were written
+	ts.now = ts.now.AddDate(0, 0, 31)
+	c.call(http.MethodGet, "/post/1", nil, http.StatusNotFound)
+}
+
+func TestLoginFailures(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newClient(t)
+	c.signupAndLogin("amal@example.com")
+
+	other := ts.newClient(t)
+	other.fetchCSRF()
+	other.call(http.MethodPost, "/user/signup", map[string]string{"name": "Amal", "email": "AMAL@example.com", "password": "a long secret"}, http.StatusBadRequest)
+	other.call(http.MethodPost, "/user/login", map[string]string{"email": "amal@example.com", "password": "not the secret"}, http.StatusUnauthorized)
+}
+
+func TestSignupRejectsLongPassword(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newClient(t)
+	c.fetchCSRF()
+
+	// bcrypt refuses to hash more than 72 bytes, which used to be a 500
+	long := strings.Repeat("x", passwords.MaxBytes+1)
+	response := c.call(http.MethodPost, "/user/signup", map[string]string{"name": "Amal", "email": "amal@example.com", "password": long}, http.StatusBadRequest)
+	if errs, _ := response.Result.(map[string]any); errs["password"] == nil {
+		t.Errorf("signup with a %d byte password = %+v, want a password error", len(long), response)
+	}
+
+	c.call(http.MethodPost, "/user/signup", map[string]string{"name": "Amal", "email": "amal@example.com", "password": long[:passwords.MaxBytes]}, http.StatusOK)
+}
+
+func TestCSRF(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newClient(t)
+	c.signupAndLogin("amal@example.com")
+
+	token := c.csrf
+	c.csrf = ""
+	c.call(http.MethodPost, "/post", map[string]string{"title": "t", "content": "c"}, http.StatusForbidden)
+
+	// A Bearer header doesn't exempt a request the session cookie logs in
+	status, raw := c.do(http.MethodPost, "/post", map[string]string{"title": "t", "content": "c"}, http.Header{"Authorization": {"Bearer x"}})
+	if status != http.StatusForbidden {
+		t.Errorf("cookie and Bearer without a token = %d %s, want 403", status, raw)
+	}
+
+	c.csrf = "wrong"
+	c.call(http.MethodPost, "/post", map[string]string{"title": "t", "content": "c"}, http.StatusForbidden)
+
+	c.csrf = token
+	c.call(http.MethodPost, "/post", map[string]string{"title": "t", "content": "c"}, http.StatusOK)
+
+	// Without a cookie a Bearer request isn't checked, and isn't logged in
+	anonymous := ts.newClient(t)
+	status, raw = anonymous.do(http.MethodDelete, "/user/me/sessions", nil, http.Header{"Authorization": {"Bearer x"}})
+	if status != http.StatusUnauthorized {
+		t.Errorf("Bearer without a cookie = %d %s, want 401", status, raw)
+	}
+}
+
+func TestTrashAndExport(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newClient(t)
+	c.signupAndLogin("amal@example.com")
+	c.call(http.MethodPost, "/post", map[string]string{"title": "Hello", "content": "World"}, http.StatusOK)
+
+	c.call(http.MethodDelete, "/post/1", nil, http.StatusOK)
+	c.call(http.MethodGet, "/post/1", nil, http.StatusNotFound)
+	c.call(http.MethodDelete, "/post/1", nil, http.StatusNotFound)
+	if trash := c.call(http.MethodGet, "/user/me/trash", nil, http.StatusOK).Result.([]any); len(trash) != 1 {
+		t.Errorf("GET /user/me/trash = %v, want the deleted post", trash)
+	}
+
+	// Someone else can neither see nor restore the post
+	other := ts.newClient(t)
+	other.signupAndLogin("other@example.com")
+	if trash := other.call(http.MethodGet, "/user/me/trash", nil, http.StatusOK).Result.([]any); len(trash) != 0 {
+		t.Errorf("another user's trash = %v, want it empty", trash)
+	}
+	other.call(http.MethodPost, "/post/1/restore", nil, http.StatusNotFound)
+
+	status, raw := c.do(http.MethodGet, "/user/me/export", nil, nil)
+	if status != http.StatusOK {
+		t.Fatalf("GET /user/me/export = %d %s", status, raw)
+	}
+	files := map[string][]any{}
+	archive, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range archive.File {
+		if file.Name == "profile.json" {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []any
+		err = json.NewDecoder(r).Decode(&records)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", file.Name, err)
+		}
+		files[file.Name] = records
+	}
+	if len(files["posts.json"]) != 0 || len(files["trash.json"]) != 1 || len(files["sessions.json"]) != 1 {
+		t.Errorf("export = %v, want the post in trash.json and one session", files)
+	}
+
+	c.call(http.MethodPost, "/post/1/restore", nil, http.StatusOK)
+	c.call(http.MethodGet, "/post/1", nil, http.StatusOK)
+
+	// The trash is purged once the retention window is over
+	c.call(http.MethodDelete, "/post/1", nil, http.StatusOK)
+	ts.now = ts.now.Add(time.Hour)
+	if count, err := ts.app.post.PurgeTrashed(context.Background(), ts.now.Add(-time.Minute)); err != nil || count != 1 {
+		t.Errorf("PurgeTrashed() = %d, %v, want 1", count, err)
+	}
+	if trash := c.call(http.MethodGet, "/user/me/trash", nil, http.StatusOK).Result.([]any); len(trash) != 0 {
+		t.Errorf("GET /user/me/trash after the purge = %v", trash)
+	}
+}
+
+func TestDeleteAccountLockout(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newClient(t)
+	c.signupAndLogin("amal@example.com")
+
+	// The password confirmation counts towards the login lockout
+	status := 0
+	for i := 0; i < 5 && status != http.StatusTooManyRequests; i++ {
+		status, _ = c.do(http.MethodDelete, "/user/me", map[string]string{"password": "not the secret"}, nil)
+	}
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("wrong passwords up to the threshold = %d, want 429", status)
+	}
+
+	c.call(http.MethodDelete, "/user/me", map[string]string{"password": "a long secret"}, http.StatusTooManyRequests)
+	if c.header.Get("Retry-After") == "" {
+		t.Error("locked out without a Retry-After header")
+	}
+
+	other := ts.newClient(t)
+	other.fetchCSRF()
+	other.call(http.MethodPost, "/user/login", map[string]string{"email": "amal@example.com", "password": "a long secret"}, http.StatusTooManyRequests)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	c := ts.newClient(t)
+	c.signupAndLogin("amal@example.com")
+	c.call(http.MethodPost, "/post", map[string]string{"title": "Hello", "content": "World"}, http.StatusOK)
+
+	c.call(http.MethodDelete, "/user/me", map[string]string{"password": "not the secret"}, http.StatusUnauthorized)
+	c.call(http.MethodDelete, "/user/me", map[string]string{"password": "a long secret"}, http.StatusAccepted)
+	c.call(http.MethodGet, "/user/me/sessions", nil, http.StatusUnauthorized)
+
+	// The email stays taken, and logging in restores the account
+	c.fetchCSRF()
+	c.call(http.MethodPost, "/user/signup", map[string]string{"name": "Amal", "email": "amal@example.com", "password": "a long secret"}, http.StatusBadRequest)
+	c.login("amal@example.com")
+	c.call(http.MethodGet, "/user/me/sessions", nil, http.StatusOK)
+	if ids, _ := ts.app.user.DueForDeletion(ctx); len(ids) != 0 {
+		t.Errorf("DueForDeletion() after logging in again = %v", ids)
+	}
+
+	// Once the grace period is over the account is purged for good
+	c.call(http.MethodDelete, "/user/me", map[string]string{"password": "a long secret"}, http.StatusAccepted)
+	if ids, _ := ts.app.user.DueForDeletion(ctx); len(ids) != 0 {
+		t.Errorf("DueForDeletion() during the grace period = %v", ids)
+	}
+	ts.now = ts.now.Add(ts.app.deletionGrace + time.Second)
+	ids, err := ts.app.user.DueForDeletion(ctx)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("DueForDeletion() once the grace period is over = %v, %v", ids, err)
+	}
+	if err = ts.app.purgeUser(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	c.fetchCSRF()
+	c.call(http.MethodPost, "/user/login", map[string]string{"email": "amal@example.com", "password": "a long secret"}, http.StatusUnauthorized)
+	post := c.call(http.MethodGet, "/post/1", nil, http.StatusOK).Result.(map[string]any)
+	if post["title"] != "Hello" {
+		t.Errorf("anonymised post = %v, want it kept", post)
+	}
+	c.call(http.MethodPost, "/user/signup", map[string]string{"name": "Amal", "email": "amal@example.com", "password": "a long secret"}, http.StatusOK)
+}
+
+func TestAdminPurge(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newClient(t)
+	c.signupAndLogin("amal@example.com")
+	c.call(http.MethodPost, "/post", map[string]string{"title": "Hello", "content": "World"}, http.StatusOK)
+	c.call(http.MethodDelete, "/post/1", nil, http.StatusOK)
+
+	const token = "0123456789abcdef0123456789abcdef"
+	purge := func(authorization string) (int, []byte) {
+		req := httptest.NewRequest(http.MethodPost, "/admin/purge?older_than=0s", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res := httptest.NewRecorder()
+		ts.app.adminRoutes().ServeHTTP(res, req)
+		return res.Code, res.Body.Bytes()
+	}
+
+	// Without a token the endpoint isn't there at all
+	if status, _ := purge("Bearer " + token); status != http.StatusNotFound {
+		t.Errorf("POST /admin/purge with no admin token configured = %d, want 404", status)
+	}
+
+	ts.app.adminToken = token
+	if status, _ := purge(""); status != http.StatusUnauthorized {
+		t.Errorf("POST /admin/purge without a token = %d, want 401", status)
+	}
+	if status, _ := purge("Basic " + token); status != http.StatusUnauthorized {
+		t.Errorf("POST /admin/purge with Basic credentials = %d, want 401", status)
+	}
+	if status, _ := purge("Bearer wrong-token"); status != http.StatusForbidden {
+		t.Errorf("POST /admin/purge with the wrong token = %d, want 403", status)
+	}
+	if trash := c.call(http.MethodGet, "/user/me/trash", nil, http.StatusOK).Result.([]any); len(trash) != 1 {
+		t.Fatalf("trash after rejected purges = %v, want the deleted post", trash)
+	}
+
+	status, raw := purge("Bearer " + token)
+	var body struct{ Result struct{ Posts int } }
+	if err := json.Unmarshal(raw, &body); err != nil || status != http.StatusOK || body.Result.Posts != 1 {
+		t.Errorf("POST /admin/purge = %d %s, want 1 post purged", status, raw)
+	}
+	if trash := c.call(http.MethodGet, "/user/me/trash", nil, http.StatusOK).Result.([]any); len(trash) != 0 {
+		t.Errorf("GET /user/me/trash after the purge = %v", trash)
+	}
+}
@@ -1,19 +1,295 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"example.com/practice-rest/internal/logging"
+	"example.com/practice-rest/internal/models"
+	"example.com/practice-rest/internal/ratelimit"
+	"example.com/practice-rest/internal/sso"
 	"example.com/practice-rest/pkg/lib"
-	"fmt"
+	"github.com/samber/lo"
+	"math"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"time"
 )
 
-func (app *application) serverError(res http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.errorLog.Output(2, trace)
+func (app *application) serverError(res http.ResponseWriter, req *http.Request, err error) {
+	app.logger.ErrorContext(req.Context(), "Internal Error", "error", err, "trace", string(debug.Stack()))
 
 	lib.WriteJSON(res, http.StatusInternalServerError, lib.InternalServerError)
 }
 
+// internalError answers a request that failed with err. A query that ran out
+// of time means the database is slow or unreachable rather than the request
+// being wrong: it gets a 503 the client may retry, or a 504 when the request
+// as a whole ran out of time or was abandoned.
+func (app *application) internalError(res http.ResponseWriter, req *http.Request, err error) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		message := "Query Timeout"
+		if errors.Is(err, context.Canceled) {
+			message = "Request Canceled"
+		}
+		app.logger.WarnContext(req.Context(), message, "error", err)
+		if req.Context().Err() != nil {
+			lib.WriteJSON(res, http.StatusGatewayTimeout, lib.GatewayTimeout)
+			return
+		}
+		res.Header().Set("Retry-After", "1")
+		lib.WriteJSON(res, http.StatusServiceUnavailable, lib.ServiceUnavailable)
+		return
+	}
+
+	app.logger.ErrorContext(req.Context(), "Internal Error", "error", err)
+	lib.WriteJSON(res, http.StatusInternalServerError, lib.InternalServerError)
+}
+
 func (app *application) pageNotFound(res http.ResponseWriter) {
 	lib.WriteJSON(res, http.StatusNotFound, lib.Response{Status: false, Result: nil, Message: "Page Not Found"})
 }
+
+// csrfSessionKey is where the synchronizer token checked by verifyCSRF lives.
+const csrfSessionKey = "csrfToken"
+
+// lastWriteSessionKey is when the user last wrote in Unix milliseconds, see
+// readYourWrites. The gob codec scs uses only knows basic types.
+const lastWriteSessionKey = "lastWriteAt"
+
+// csrfToken returns the session's CSRF token, generating one if needed.
+func (app *application) csrfToken(req *http.Request) (string, error) {
+	if token := app.sessionManger.GetString(req.Context(), csrfSessionKey); token != "" {
+		return token, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	app.sessionManger.Put(req.Context(), csrfSessionKey, token)
+	return token, nil
+}
+
+// tooManyRequests tells the client to back off for retryAfter, rounded up to
+// whole seconds as required by the Retry-After header.
+func (app *application) tooManyRequests(res http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	res.Header().Set("Retry-After", strconv.Itoa(seconds))
+	lib.WriteJSON(res, http.StatusTooManyRequests, lib.TooManyRequests)
+}
+
+// Rate limit classes, see rateLimit and rateLimitUser.
+const (
+	rateLimitDefault = "default"
+	rateLimitAuth    = "auth"
+	rateLimitUser    = "user"
+)
+
+// routeClass picks the rate limit class for a request. Signup and login are
+// what password sprayers and sign-up spammers hit, so they get their own.
+func routeClass(req *http.Request) string {
+	if req.Method == http.MethodPost && (req.URL.Path == "/user/signup" || req.URL.Path == "/user/login") {
+		return rateLimitAuth
+	}
+	return rateLimitDefault
+}
+
+// setRateLimitHeaders reports a rate limit result using the RateLimit header
+// fields from the IETF httpapi draft, in whole seconds.
+func setRateLimitHeaders(res http.ResponseWriter, result ratelimit.Result) {
+	res.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	res.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	res.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+}
+
+// clientIP returns the address of the peer without the port.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// authenticatedUserID returns the id of the logged-in user, or zero when the
+// request is anonymous.
+func (app *application) authenticatedUserID(req *http.Request) int {
+	return app.sessionManger.GetInt(req.Context(), "authenticatedUserID")
+}
+
+// revoke deletes session from the session store and from the index. Revoking
+// the session the request was made with destroys it in the request context as
+// well, so LoadAndSave doesn't write it back.
+func (app *application) revoke(req *http.Request, session *models.Session) error {
+	if session.Token == app.sessionManger.Token(req.Context()) {
+		if err := app.sessionManger.Destroy(req.Context()); err != nil {
+			return err
+		}
+	} else if err := app.sessionManger.Store.Delete(session.Token); err != nil {
+		return err
+	}
+
+	return app.session.Delete(req.Context(), session.ID)
+}
+
+// authenticate checks email and password behind the login lockout and returns
+// the user they belong to. Otherwise it writes the response, 429 with
+// Retry-After while locked out and 401 for wrong credentials, and ok is false.
+func (app *application) authenticate(res http.ResponseWriter, req *http.Request, email, password string) (_ int, ok bool) {
+	ip := clientIP(req)
+	retryAfter, err := app.loginGuard.Check(email, ip)
+	if lo.IsNotEmpty(err) {
+		app.internalError(res, req, err)
+		return 0, false
+	}
+	if retryAfter > 0 {
+		app.logger.InfoContext(req.Context(), "Login Locked", "email", email, "ip", ip, "retry_after", retryAfter)
+		app.tooManyRequests(res, retryAfter)
+		return 0, false
+	}
+
+	id, err := app.user.Authenticate(req.Context(), email, password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.logger.InfoContext(req.Context(), "Invalid Credentials", "email", email, "ip", ip)
+			retryAfter, err = app.loginGuard.Fail(email, ip)
+			if lo.IsNotEmpty(err) {
+				app.internalError(res, req, err)
+				return 0, false
+			}
+			if retryAfter > 0 {
+				app.tooManyRequests(res, retryAfter)
+				return 0, false
+			}
+			lib.WriteJSON(res, http.StatusUnauthorized, lib.Response{Status: false, Result: nil, Message: "Invalid Credentials"})
+			return 0, false
+		}
+		app.internalError(res, req, err)
+		return 0, false
+	}
+
+	if err = app.loginGuard.Succeed(email, ip); err != nil {
+		app.logger.ErrorContext(req.Context(), "Internal Error", "error", err)
+	}
+	return id, true
+}
+
+// startSession logs userID in on the request's session. The token is renewed
+// to prevent session fixation and indexed by user so the session can be listed
+// and revoked later. An account deleted during its grace period is restored.
+func (app *application) startSession(req *http.Request, userID int) error {
+	err := app.sessionManger.RenewToken(req.Context())
+	if err != nil {
+		return err
+	}
+
+	app.sessionManger.Put(req.Context(), "authenticatedUserID", userID)
+	app.sessionManger.Put(req.Context(), lastWriteSessionKey, time.Now().UnixMilli())
+	logging.SetUserID(req.Context(), userID)
+
+	// A token issued before login could have been planted along with the
+	// session, so the client has to fetch a new one from GET /csrf.
+	app.sessionManger.Remove(req.Context(), csrfSessionKey)
+
+	// Logging back in during the grace period keeps the account
+	cancelled, err := app.user.CancelDeletion(req.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		app.logger.InfoContext(req.Context(), "Account Deletion Cancelled", "id", userID)
+	}
+
+	_, err = app.session.Insert(req.Context(), userID, app.sessionManger.Token(req.Context()), clientIP(req), req.UserAgent())
+	return err
+}
+
+// linkIdentity returns the user an external identity belongs to. Identities
+// seen for the first time are linked to the user with the same email, or to a
+// new password-less user, but only if the provider verified the email.
+func (app *application) linkIdentity(ctx context.Context, identity *sso.Identity) (int, error) {
+	id, err := app.identity.UserID(ctx, identity.Provider, identity.Subject)
+	if err == nil || !errors.Is(err, models.ErrNoRecord) {
+		return id, err
+	}
+
+	if !identity.EmailVerified || identity.Email == "" {
+		return 0, sso.ErrUnverifiedEmail
+	}
+
+	// A new user is only kept if the identity could be linked to it
+	err = app.unitOfWork.Do(ctx, func(ctx context.Context, repos models.Repositories) error {
+		id, err = repos.Users.IDByEmail(ctx, identity.Email)
+		if errors.Is(err, models.ErrNoRecord) {
+			name := identity.Name
+			if name == "" {
+				name = identity.Email
+			}
+			id, err = repos.Users.Insert(ctx, name, identity.Email, "")
+		}
+		if err != nil {
+			return err
+		}
+
+		return repos.Identities.Insert(ctx, id, identity.Provider, identity.Subject, identity.Email)
+	})
+	return id, err
+}
+
+// newRequestID returns a random id for requests that didn't come with one.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether an X-Request-ID sent by the client is safe to
+// reuse in logs and headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// responseRecorder captures the status code and body size for requestLogger.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
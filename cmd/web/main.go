@@ -1,97 +1,396 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"database/sql"
+	"example.com/practice-rest/internal/certs"
+	"example.com/practice-rest/internal/cors"
+	"example.com/practice-rest/internal/health"
+	"example.com/practice-rest/internal/lockout"
+	"example.com/practice-rest/internal/logging"
+	"example.com/practice-rest/internal/metrics"
 	"example.com/practice-rest/internal/models"
+	"example.com/practice-rest/internal/passwords"
+	"example.com/practice-rest/internal/ratelimit"
+	"example.com/practice-rest/internal/sqldb"
+	"example.com/practice-rest/internal/sso"
+	"example.com/practice-rest/internal/tracing"
+	"example.com/practice-rest/pkg/config"
 	"flag"
-	"github.com/alexedwards/scs/mysqlstore"
+	"fmt"
 	"github.com/alexedwards/scs/v2"
-	_ "github.com/go-sql-driver/mysql"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"runtime"
 	"time"
 )
 
 // TODO - create a struct to hold application-wide dependencies
 type application struct {
-	errorLog      *log.Logger
-	infoLog       *log.Logger
-	debugLog      *log.Logger
-	httpLog       *log.Logger
-	post          *models.PostModel
-	user          *models.UserModel
-	sessionManger *scs.SessionManager
+	logger         *slog.Logger
+	post           models.PostRepository
+	user           models.UserRepository
+	session        models.SessionRepository
+	sessionManger  *scs.SessionManager
+	loginGuard     *lockout.Guard
+	identity       models.IdentityRepository
+	unitOfWork     models.UnitOfWork
+	sso            sso.Registry
+	deletionGrace  time.Duration
+	deletionPolicy string
+	trashRetention time.Duration
+	purgeInterval  time.Duration
+	hasher         *passwords.Hasher
+	cors           *cors.Policy
+	limiter        *ratelimit.Limiter
+	rateLimits     map[string]ratelimit.Limit
+	metrics        *metrics.Metrics
+	health         *health.Checker
+	adminToken     string
+	maxBodyBytes   int64
+
+	// Only set when reads are spread over replicas
+	replicas             *sqldb.Replicas
+	replicaCheckInterval time.Duration
+	readYourWritesWindow time.Duration
+
+	// Only set when serving TLS from certificate files
+	certs              *certs.Reloader
+	certReloadInterval time.Duration
+	hsts               string
 }
 
 // With http.NewServeMux()
 func main() {
-	// flag is to define a command line flag, so then we are passing like this -addr=":5000"
-	addr := flag.String("addr", "localhost:5000", "HTTP network address to start the server")
-	dsn := flag.String("dsn", "root:root@/go_practice?parseTime=true", "MySQL data source name")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		os.Exit(purge(os.Args[2:]))
+	}
+
+	// Every setting has a flag, an environment variable and a config file
+	// entry, see pkg/config
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logger, err := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: "practice-rest",
+	})
+	if err != nil {
+		fatal(logger, "Invalid Configuration", err)
+	}
 
-	// Parse parses the command-line flags from os.Args[1:]. Must be called after all flags are defined and before flags are accessed by the program.
-	flag.Parse()
+	argon := passwords.DefaultArgon2id
+	argon.Memory = cfg.Passwords.Argon2Memory
+	argon.Iterations = cfg.Passwords.Argon2Iterations
+	argon.Parallelism = cfg.Passwords.Argon2Parallelism
+	bcryptAlgorithm := passwords.Bcrypt{Cost: cfg.Passwords.BcryptCost}
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	debugLog := log.New(os.Stdout, "DEBUG\t", log.Ldate|log.Ltime|log.Lshortfile)
-	httpLog := log.New(os.Stdout, "HTTP\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	var hasher *passwords.Hasher
+	switch cfg.Passwords.Algorithm {
+	case "argon2id":
+		hasher = &passwords.Hasher{Current: argon, Legacy: []passwords.Algorithm{bcryptAlgorithm}}
+	case "bcrypt":
+		hasher = &passwords.Hasher{Current: bcryptAlgorithm, Legacy: []passwords.Algorithm{argon}}
+	default:
+		fatal(logger, "Invalid Configuration", fmt.Errorf("unknown password algorithm %q", cfg.Passwords.Algorithm))
+	}
 
-	db, err := openDB(*dsn)
+	store, err := openStorage(cfg.Database, hasher)
 	if err != nil {
-		errorLog.Fatal(err)
+		fatal(logger, "Opening Database Failed", err)
 	}
 
-	defer db.Close()
+	if store.migrator != nil && cfg.Database.MigrateOnStart {
+		applied, err := store.migrator.Up(context.Background())
+		for _, m := range applied {
+			logger.Info("Migration Applied", "version", m.Version, "name", m.Name)
+		}
+		if err != nil {
+			fatal(logger, "Migration Failed", err)
+		}
+	}
+
+	// A schema the code doesn't match fails fast, unless migrations are still
+	// pending: then it's expected, and the readiness check keeps the instance
+	// out of rotation until they are applied
+	if store.db != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		pending, err := store.migrator.Pending(ctx)
+		if err != nil {
+			fatal(logger, "Checking Migrations Failed", err)
+		}
+		if pending > 0 {
+			logger.Warn("Migrations Pending", "pending_migrations", pending, "hint", "run the migrate command or start with -migrate-on-start")
+		} else if err = models.CheckSchema(ctx, store.db, store.dialect); err != nil {
+			fatal(logger, "Schema Check Failed", err)
+		}
+		cancel()
+	}
 
 	// Initializing the session manager using cookies for now,
 	// later I'll use jwt to manage the session
 	sessionManger := scs.New()
-	sessionManger.Store = mysqlstore.New(db)
-	sessionManger.Lifetime = 12 * time.Hour
-	sessionManger.Cookie.Secure = true
+	appMetrics := metrics.New(store.db, "go_practice")
+
+	sessionManger.Store = appMetrics.SessionStore(store.sessions)
+	sessionManger.Lifetime = cfg.Session.Lifetime
+	sessionManger.Cookie.Secure = cfg.Session.CookieSecure
+	sessionManger.Cookie.HttpOnly = true
+	sessionManger.Cookie.SameSite = http.SameSiteLaxMode
+
+	var attempts lockout.Store
+	switch cfg.Lockout.Store {
+	case "database", "mysql":
+		attempts = &lockout.SQLStore{DB: store.db, Dialect: store.dialect}
+	case "memory":
+		attempts = lockout.NewMemoryStore(logger)
+	default:
+		fatal(logger, "Invalid Configuration", fmt.Errorf("unknown lockout store %q", cfg.Lockout.Store))
+	}
+
+	loginGuard := &lockout.Guard{
+		Store:   attempts,
+		Account: lockout.Policy{Threshold: cfg.Lockout.AccountThreshold, BaseDelay: cfg.Lockout.BaseDelay, MaxDelay: cfg.Lockout.MaxDelay, Window: cfg.Lockout.Window},
+		IP:      lockout.Policy{Threshold: cfg.Lockout.IPThreshold, BaseDelay: cfg.Lockout.BaseDelay, MaxDelay: cfg.Lockout.MaxDelay, Window: cfg.Lockout.Window},
+	}
+
+	var buckets ratelimit.Store
+	switch cfg.RateLimit.Store {
+	case "memory":
+		buckets = ratelimit.NewMemoryStore()
+	case "database", "mysql":
+		buckets = &ratelimit.SQLStore{DB: store.db, Dialect: store.dialect}
+	default:
+		fatal(logger, "Invalid Configuration", fmt.Errorf("unknown rate limit store %q", cfg.RateLimit.Store))
+	}
+
+	// Provider discovery happens once at startup, a provider that can't be
+	// reached is a configuration error rather than something to retry per login.
+	providers := sso.Registry{}
+	if cfg.SSO.ConfigFile != "" {
+		configs, err := sso.LoadConfig(cfg.SSO.ConfigFile)
+		if err != nil {
+			fatal(logger, "Invalid Configuration", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		providers, err = sso.NewRegistry(ctx, configs)
+		cancel()
+		if err != nil {
+			fatal(logger, "Provider Discovery Failed", err)
+		}
+	}
 
 	app := &application{
-		errorLog:      errorLog,
-		infoLog:       infoLog,
-		debugLog:      debugLog,
-		httpLog:       httpLog,
-		post:          &models.PostModel{DB: db},
-		user: 		   &models.UserModel{DB: db},
-		sessionManger: sessionManger,
+		logger:         logger,
+		post:           store.post,
+		user:           store.user,
+		session:        store.session,
+		sessionManger:  sessionManger,
+		loginGuard:     loginGuard,
+		identity:       store.identity,
+		unitOfWork:     store.unitOfWork,
+		sso:            providers,
+		deletionGrace:  cfg.Deletion.Grace,
+		deletionPolicy: cfg.Deletion.Policy,
+		trashRetention: cfg.Deletion.Retention,
+		purgeInterval:  cfg.Deletion.PurgeInterval,
+		hasher:         hasher,
+
+		replicas:             store.replicas,
+		replicaCheckInterval: cfg.Database.ReplicaCheckInterval,
+		readYourWritesWindow: cfg.Database.ReadYourWrites,
+
+		cors: &cors.Policy{
+			AllowedOrigins:   cfg.CORS.Origins,
+			AllowedMethods:   cfg.CORS.Methods,
+			AllowedHeaders:   cfg.CORS.Headers,
+			ExposedHeaders:   cfg.CORS.Expose,
+			AllowCredentials: cfg.CORS.Credentials,
+			MaxAge:           cfg.CORS.MaxAge,
+		},
+		limiter: &ratelimit.Limiter{Store: buckets},
+		rateLimits: map[string]ratelimit.Limit{
+			rateLimitDefault: ratelimit.PerMinute(cfg.RateLimit.IP),
+			rateLimitAuth:    ratelimit.PerMinute(cfg.RateLimit.Auth),
+			rateLimitUser:    ratelimit.PerMinute(cfg.RateLimit.User),
+		},
+		metrics:      appMetrics,
+		maxBodyBytes: cfg.Server.MaxBodyBytes,
+		adminToken:   cfg.Metrics.AdminToken,
 	}
 
-	tlsConfig := tls.Config{
-		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	// Everything the API can't serve requests without
+	checker := &health.Checker{Timeout: cfg.Health.ReadinessTimeout}
+	if store.db != nil {
+		checker.Register("database", store.db.PingContext)
+	}
+	checker.Register("session_store", func(ctx context.Context) error {
+		_, _, err := sessionManger.Store.Find("readiness-probe")
+		return err
+	})
+	if cfg.Lockout.Store != "memory" {
+		checker.Register("lockout_store", func(ctx context.Context) error {
+			_, err := attempts.Get("readiness-probe")
+			return err
+		})
+	}
+	// Serving against an older schema fails in ways that are hard to
+	// diagnose, so the instance stays out of rotation until it's migrated
+	if store.migrator != nil {
+		checker.Register("migrations", func(ctx context.Context) error {
+			pending, err := store.migrator.Pending(ctx)
+			if err == nil && pending > 0 {
+				err = fmt.Errorf("%d migrations pending", pending)
+			}
+			return err
+		})
+	}
+	app.health = checker
+
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled() {
+		tlsConfig = &tls.Config{
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		}
+
+		if cfg.TLS.Dev {
+			cert, err := certs.SelfSigned(devHosts(cfg.Server.Addr))
+			if err != nil {
+				fatal(logger, "Generating Certificate Failed", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+			logger.Warn("Serving Self-Signed Certificate", "hosts", devHosts(cfg.Server.Addr), "expires", cert.Leaf.NotAfter)
+		} else {
+			reloader, err := certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			if err != nil {
+				fatal(logger, "Invalid Configuration", err)
+			}
+			tlsConfig.GetCertificate = reloader.GetCertificate
+			app.certs = reloader
+			app.certReloadInterval = cfg.TLS.ReloadInterval
+			// Browsers remember HSTS, so it's never sent for dev certificates
+			if cfg.TLS.HSTSMaxAge > 0 {
+				app.hsts = fmt.Sprintf("max-age=%d; includeSubDomains", int(cfg.TLS.HSTSMaxAge.Seconds()))
+			}
+		}
+
+		switch cfg.TLS.ClientAuth {
+		case "optional":
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		case "require":
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		if cfg.TLS.ClientCAFile != "" {
+			pool, err := certs.ClientCAs(cfg.TLS.ClientCAFile)
+			if err != nil {
+				fatal(logger, "Invalid Configuration", err)
+			}
+			tlsConfig.ClientCAs = pool
+		}
 	}
 
 	srv := &http.Server{
-		Addr:     *addr,
-		ErrorLog: errorLog,
-		Handler:  app.routes(),
-		TLSConfig: &tlsConfig,
-		IdleTimeout: time.Minute,
-		ReadTimeout: 5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-	}
-
-	infoLog.Printf("Server started on %s", *addr)
-	//err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	err = srv.ListenAndServe()
-	errorLog.Fatal(err)
-}
+		Addr:         cfg.Server.Addr,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		Handler:      app.routes(),
+		TLSConfig:    tlsConfig,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+
+	servers := []*http.Server{srv}
+
+	// Metrics and admin actions are served on their own listener so they're
+	// never reachable through whatever exposes the API to the internet
+	if cfg.Metrics.Addr != "" {
+		servers = append(servers, &http.Server{
+			Addr:        cfg.Metrics.Addr,
+			ErrorLog:    srv.ErrorLog,
+			Handler:     app.adminRoutes(),
+			ReadTimeout: 5 * time.Second,
+		})
+	}
+
+	if cfg.TLS.RedirectAddr != "" {
+		servers = append(servers, &http.Server{
+			Addr:         cfg.TLS.RedirectAddr,
+			ErrorLog:     srv.ErrorLog,
+			Handler:      redirectToHTTPS(cfg.Server.Addr),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+		})
+	}
+
+	err = app.serve(servers, shutdownConfig{Delay: cfg.Shutdown.Delay, Timeout: cfg.Shutdown.Timeout})
+
+	// Deferred calls don't run on os.Exit, so everything is closed by hand
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if errTracing := shutdownTracing(ctx); errTracing != nil {
+		logger.Error("Flushing Traces Failed", "error", errTracing)
+	}
+	cancel()
+	if errDB := store.Close(); errDB != nil {
+		logger.Error("Closing Database Failed", "error", errDB)
+	}
 
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		return nil, err
+		fatal(logger, "Server Stopped", err)
 	}
+	logger.Info("Server Stopped")
+}
 
-	if err = db.Ping(); err != nil {
-		return nil, err
+// fatal logs err and exits, which is what log.Fatal did before logging moved
+// to slog. The record is attributed to the caller of fatal, so the source of
+// the log line points at what failed rather than here.
+func fatal(logger *slog.Logger, msg string, err error) {
+	if logger.Enabled(context.Background(), slog.LevelError) {
+		var pcs [1]uintptr
+		// Skip runtime.Callers and fatal itself
+		runtime.Callers(2, pcs[:])
+		record := slog.NewRecord(time.Now(), slog.LevelError, msg, pcs[0])
+		record.AddAttrs(slog.Any("error", err))
+		logger.Handler().Handle(context.Background(), record)
 	}
+	os.Exit(1)
+}
 
-	return db, nil
+// devHosts lists the names a -dev-tls certificate is issued for, the host in
+// addr plus the loopback names.
+func devHosts(addr string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || host == "localhost" {
+		return hosts
+	}
+	if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+		return hosts
+	}
+	return append(hosts, host)
 }
@@ -1,23 +1,65 @@
 package main
 
-import "net/http"
+import (
+	"crypto/subtle"
+	"example.com/practice-rest/internal/logging"
+	"example.com/practice-rest/internal/sqldb"
+	"example.com/practice-rest/pkg/lib"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Add the secure headers middleware based on the OWASP specification
 // https://owasp.org/www-project-secure-headers/index.html#configuration-proposal
-func secureHeaders(next http.Handler) http.Handler {
+func (app *application) secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Set("X-Content-Type-Options", "nosniff")
 		res.Header().Set("X-Frame-Options", "deny")
 		res.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+		// Browsers ignore HSTS received over plain HTTP anyway
+		if req.TLS != nil && app.hsts != "" {
+			res.Header().Set("Strict-Transport-Security", app.hsts)
+		}
 
 		next.ServeHTTP(res, req)
 	})
 }
 
+// requestLogger gives every request an id, taken from X-Request-ID when the
+// client (or a proxy in front of us) sent a sane one, and logs the outcome once
+// the handler is done. It must come right after tracing.Middleware, which is
+// the outermost so the request line carries the trace id, and before every
+// other middleware so everything logged further down carries the request id.
 func (app *application) requestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		app.httpLog.Printf("%s - %s %s %s", req.RemoteAddr, req.Proto, req.Method, req.URL.RequestURI())
-		next.ServeHTTP(res, req)
+		id := req.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		res.Header().Set("X-Request-ID", id)
+
+		ctx := logging.WithRequestID(req.Context(), id)
+		req = req.WithContext(ctx)
+		recorder := &responseRecorder{ResponseWriter: res}
+		start := time.Now()
+
+		defer func() {
+			app.logger.LogAttrs(ctx, slog.LevelInfo, "Request",
+				slog.String("ip", clientIP(req)),
+				slog.String("proto", req.Proto),
+				slog.String("method", req.Method),
+				slog.String("uri", req.URL.RequestURI()),
+				slog.Int("status", recorder.status),
+				slog.Int("bytes", recorder.bytes),
+				slog.Duration("latency", time.Since(start)),
+			)
+		}()
+
+		next.ServeHTTP(recorder, req)
 	})
 }
 
@@ -30,9 +72,181 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 			// panic or not. If there has...
 			if err := recover(); err != nil {
 				res.Header().Set("Connection", "close")
-				app.serverError(res, err.(error))
+				app.serverError(res, req, fmt.Errorf("%v", err))
 			}
 		}()
 		next.ServeHTTP(res, req)
 	})
-}
\ No newline at end of file
+}
+
+// requireAuthentication rejects anonymous requests and keeps the last seen
+// time of the session index up to date. It must run after LoadAndSave.
+func (app *application) requireAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if app.authenticatedUserID(req) == 0 {
+			lib.WriteJSON(res, http.StatusUnauthorized, lib.Unauthorized)
+			return
+		}
+
+		if err := app.session.Touch(req.Context(), app.sessionManger.Token(req.Context())); err != nil {
+			app.logger.ErrorContext(req.Context(), "Internal Error", "error", err)
+		}
+
+		// Don't let caches keep responses that depend on who is logged in.
+		res.Header().Add("Cache-Control", "no-store")
+		next.ServeHTTP(res, req)
+	})
+}
+
+// requireAdmin lets through requests that carry the configured admin token as
+// a Bearer token. Requests without one get a 401, requests with the wrong one
+// a 403.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			res.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			lib.WriteJSON(res, http.StatusUnauthorized, lib.Unauthorized)
+			return
+		}
+
+		if app.adminToken == "" || subtle.ConstantTimeCompare([]byte(app.adminToken), []byte(token)) != 1 {
+			app.logger.WarnContext(req.Context(), "Invalid Admin Token", "method", req.Method, "path", req.URL.Path, "ip", clientIP(req))
+			lib.WriteJSON(res, http.StatusForbidden, lib.Forbidden)
+			return
+		}
+
+		res.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(res, req)
+	})
+}
+
+// verifyCSRF rejects state-changing requests that don't echo the session's
+// synchronizer token (see GET /csrf) in the X-CSRF-Token header. Requests with
+// a Bearer Authorization header and no session cookie are exempt: nothing
+// authenticates them that a browser would attach on its own, so they can't be
+// forged cross-site. A request that also sends the session cookie is checked
+// like any other, the cookie is what logs it in. It must run after
+// LoadAndSave.
+func (app *application) verifyCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Add("Vary", "Cookie")
+
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		if strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+			if _, err := req.Cookie(app.sessionManger.Cookie.Name); err != nil {
+				next.ServeHTTP(res, req)
+				return
+			}
+		}
+
+		expected := app.sessionManger.GetString(req.Context(), csrfSessionKey)
+		actual := req.Header.Get("X-CSRF-Token")
+		if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+			app.logger.InfoContext(req.Context(), "Invalid CSRF Token", "method", req.Method, "path", req.URL.Path)
+			lib.WriteJSON(res, http.StatusForbidden, lib.InvalidCSRFToken)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// rateLimit limits requests per client IP, with a stricter limit for the
+// routes in the "auth" class. Limiter failures let the request through rather
+// than taking the API down with the store.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		class := routeClass(req)
+
+		result, err := app.limiter.Take(class+":ip:"+clientIP(req), app.rateLimits[class])
+		if err != nil {
+			app.logger.ErrorContext(req.Context(), "Internal Error", "error", err)
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		setRateLimitHeaders(res, result)
+		if !result.Allowed {
+			app.tooManyRequests(res, result.RetryAfter)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// rateLimitUser limits requests per logged-in user on top of the per IP limit,
+// so a user can't get around it by spreading requests over addresses. It must
+// run after LoadAndSave.
+func (app *application) rateLimitUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		userID := app.authenticatedUserID(req)
+		if userID == 0 {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		result, err := app.limiter.Take("user:"+strconv.Itoa(userID), app.rateLimits[rateLimitUser])
+		if err != nil {
+			app.logger.ErrorContext(req.Context(), "Internal Error", "error", err)
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		// Report whichever of the two limits is closer to running out
+		ipRemaining, err := strconv.Atoi(res.Header().Get("RateLimit-Remaining"))
+		if err != nil || result.Remaining < ipRemaining {
+			setRateLimitHeaders(res, result)
+		}
+		if !result.Allowed {
+			app.tooManyRequests(res, result.RetryAfter)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// annotateUser adds the logged-in user to the request's log lines. It must run
+// after LoadAndSave.
+func (app *application) annotateUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if userID := app.authenticatedUserID(req); userID != 0 {
+			logging.SetUserID(req.Context(), userID)
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
+// readYourWrites sends the reads of a logged-in user to the primary for a
+// while after they last changed something, so they see their own writes
+// before the replicas catch up. Requests that write read from the primary
+// throughout. It must run after LoadAndSave.
+func (app *application) readYourWrites(next http.Handler) http.Handler {
+	if app.replicas == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			lastWrite := time.UnixMilli(app.sessionManger.GetInt64(ctx, lastWriteSessionKey))
+			if time.Since(lastWrite) < app.readYourWritesWindow {
+				ctx = sqldb.WithPrimary(ctx)
+			}
+		default:
+			if app.authenticatedUserID(req) != 0 {
+				app.sessionManger.Put(ctx, lastWriteSessionKey, time.Now().UnixMilli())
+			}
+			ctx = sqldb.WithPrimary(ctx)
+		}
+		next.ServeHTTP(res, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"example.com/practice-rest/internal/migrations"
+	"example.com/practice-rest/pkg/config"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// migrationLockTimeout bounds how long to wait for another instance that is
+// migrating the same database.
+const migrationLockTimeout = time.Minute
+
+// migrate runs "web migrate", which takes the same flags as the server
+// followed by one of:
+//
+//	up           apply every pending migration
+//	down [N]     revert the latest N migrations, 1 by default
+//	status       list the migrations and whether they were applied
+//	create NAME  write empty up and down files for a new migration
+//
+// It returns the exit code.
+func migrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dir := fs.String("dir", "internal/migrations", "Directory holding a directory of migrations per database, where create writes new ones")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s migrate [flags] up | down [N] | status | create NAME\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.Load(fs, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	switch fs.Arg(0) {
+	case "create":
+		if fs.NArg() != 2 {
+			fs.Usage()
+			return 2
+		}
+		created, err := migrations.Create(*dir, fs.Arg(1))
+		for _, file := range created {
+			fmt.Println("Created", file)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	case "up", "down", "status":
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	steps := 1
+	if fs.Arg(0) == "down" && fs.NArg() > 1 {
+		steps, err = strconv.Atoi(fs.Arg(1))
+		if err != nil || steps < 1 {
+			fmt.Fprintf(os.Stderr, "invalid number of migrations %q\n", fs.Arg(1))
+			return 2
+		}
+	}
+
+	store, err := openStorage(cfg.Database, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer store.Close()
+
+	migrator := store.migrator
+	if migrator == nil {
+		fmt.Fprintln(os.Stderr, "the database has no schema to migrate")
+		return 2
+	}
+	ctx := context.Background()
+
+	switch fs.Arg(0) {
+	case "up":
+		var applied []migrations.Migration
+		applied, err = migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied  %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Nothing to apply")
+		}
+	case "down":
+		var reverted []migrations.Migration
+		reverted, err = migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", m.Version, m.Name)
+		}
+	case "status":
+		var list []migrations.Status
+		list, err = migrator.Status(ctx)
+		for _, s := range list {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			if s.Modified {
+				state += " (modified since)"
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
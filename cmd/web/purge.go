@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"example.com/practice-rest/pkg/config"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// purge runs "web purge", which takes the same flags as the server and hard
+// deletes the posts that have been in the trash for longer than -older-than,
+// the retention window unless given, without waiting for the deletion worker.
+// It returns the exit code.
+func purge(args []string) int {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 0, "Purge what has been in the trash for longer than this, deletion.retention if not given")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s purge [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.Load(fs, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	given := false
+	fs.Visit(func(f *flag.Flag) { given = given || f.Name == "older-than" })
+	if !given {
+		*olderThan = cfg.Deletion.Retention
+	}
+	if *olderThan < 0 {
+		fmt.Fprintln(os.Stderr, "-older-than must not be negative")
+		return 2
+	}
+
+	store, err := openStorage(cfg.Database, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer store.Close()
+
+	if store.migrator == nil {
+		fmt.Fprintln(os.Stderr, "the database is kept in memory, there is no trash to purge")
+		return 2
+	}
+
+	count, err := store.post.PurgeTrashed(context.Background(), time.Now().Add(-*olderThan))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Purged %d posts\n", count)
+	return 0
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"example.com/practice-rest/internal/tracing"
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 	"net/http"
@@ -9,20 +10,57 @@ import (
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
-	dynamic := alice.New(app.sessionManger.LoadAndSave)
-	router.HandlerFunc(http.MethodGet,"/", healthCheck)
-	router.Handler(http.MethodGet,"/post/:id", dynamic.ThenFunc(app.getSinglePost))
-	router.Handler(http.MethodGet,"/post", dynamic.ThenFunc(app.getPosts))
-	router.Handler(http.MethodPost, "/post", dynamic.ThenFunc(app.createPost))
+	// Every route goes through handle so metrics and spans are labelled with
+	// the pattern rather than the raw path
+	handle := func(method, pattern string, handler http.Handler) {
+		router.Handler(method, pattern, tracing.Route(pattern, app.metrics.Route(pattern, handler)))
+	}
 
-	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignup))
-	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLogin))
-	router.Handler(http.MethodPost, "/user/logout", dynamic.ThenFunc(app.userLogout))
+	dynamic := alice.New(app.sessionManger.LoadAndSave, app.annotateUser, app.readYourWrites, app.rateLimitUser, app.verifyCSRF)
+	handle(http.MethodGet, "/", http.HandlerFunc(healthCheck))
+	handle(http.MethodGet, "/healthz", http.HandlerFunc(healthCheck))
+	handle(http.MethodGet, "/readyz", http.HandlerFunc(app.readinessCheck))
+	handle(http.MethodGet, "/csrf", dynamic.ThenFunc(app.getCSRFToken))
+	handle(http.MethodGet, "/post/:id", dynamic.ThenFunc(app.getSinglePost))
+	handle(http.MethodGet, "/post", dynamic.ThenFunc(app.getPosts))
+	handle(http.MethodPost, "/post", dynamic.ThenFunc(app.createPost))
+
+	handle(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignup))
+	handle(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLogin))
+	handle(http.MethodPost, "/user/logout", dynamic.ThenFunc(app.userLogout))
+
+	handle(http.MethodGet, "/auth/:provider/login", dynamic.ThenFunc(app.ssoLogin))
+	handle(http.MethodGet, "/auth/:provider/callback", dynamic.ThenFunc(app.ssoCallback))
+
+	protected := dynamic.Append(app.requireAuthentication)
+	handle(http.MethodDelete, "/post/:id", protected.ThenFunc(app.deletePost))
+	handle(http.MethodPost, "/post/:id/restore", protected.ThenFunc(app.restorePost))
+	handle(http.MethodDelete, "/user/me", protected.ThenFunc(app.deleteAccount))
+	handle(http.MethodGet, "/user/me/export", protected.ThenFunc(app.exportAccount))
+	handle(http.MethodGet, "/user/me/trash", protected.ThenFunc(app.getTrash))
+	handle(http.MethodGet, "/user/me/sessions", protected.ThenFunc(app.listSessions))
+	handle(http.MethodDelete, "/user/me/sessions", protected.ThenFunc(app.revokeAllSessions))
+	handle(http.MethodDelete, "/user/me/sessions/:id", protected.ThenFunc(app.revokeSession))
+
+	// httprouter answers OPTIONS for every registered path and sets the Allow
+	// header before handing over to GlobalOPTIONS, which handles CORS preflight
+	router.GlobalOPTIONS = http.HandlerFunc(app.cors.Preflight)
 
 	router.NotFound = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		app.pageNotFound(res)
 	})
 
-	standard := alice.New(app.recoverPanic, app.requestLogger, secureHeaders)
+	standard := alice.New(tracing.Middleware, app.requestLogger, app.metrics.Middleware, app.recoverPanic, app.secureHeaders, app.cors.Handler, app.rateLimit)
 	return standard.Then(router)
 }
+
+// adminRoutes is served on the admin listener. The admin actions are only
+// there when an admin token is configured, and every one of them needs it.
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.metrics.Handler())
+	if app.adminToken != "" {
+		mux.Handle("/admin/purge", app.requireAdmin(http.HandlerFunc(app.purgeTrash)))
+	}
+	return mux
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownConfig controls how the process winds down on SIGINT or SIGTERM.
+type shutdownConfig struct {
+	// Delay is how long /readyz reports not ready before the listeners close,
+	// giving load balancers time to stop sending new requests.
+	Delay time.Duration
+	// Timeout bounds how long in-flight requests get to finish.
+	Timeout time.Duration
+}
+
+// serve runs the servers (the API first, then the admin and redirect servers
+// if configured) and the background workers until a signal arrives or a server
+// fails, then shuts everything down in order. It returns nil only for a clean
+// shutdown after a signal.
+func (app *application) serve(servers []*http.Server, config shutdownConfig) error {
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	workers, stopWorkers := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		app.runDeletionPurger(workers, app.purgeInterval)
+	}()
+	go func() {
+		defer wg.Done()
+		app.runRateLimitSweeper(workers, 5*time.Minute, time.Hour)
+	}()
+	if app.replicas != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.runReplicaChecker(workers, app.replicaCheckInterval)
+		}()
+	}
+	if app.certs != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.runCertReloader(workers, app.certReloadInterval)
+		}()
+	}
+
+	failed := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			app.logger.Info("Server Started", "addr", server.Addr, "tls", server.TLSConfig != nil)
+			var err error
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				failed <- err
+			}
+		}(server)
+	}
+
+	var serveErr error
+	select {
+	case <-signals.Done():
+		app.logger.Info("Shutting Down", "delay", config.Delay, "timeout", config.Timeout)
+	case serveErr = <-failed:
+		app.logger.Error("Server Failed", "error", serveErr)
+	}
+	// A second signal kills the process straight away
+	stopSignals()
+
+	app.health.Drain()
+	if serveErr == nil {
+		time.Sleep(config.Delay)
+	}
+
+	// Shutdown stops accepting connections and waits for in-flight requests
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	var errs []error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+			server.Close()
+		}
+	}
+
+	stopWorkers()
+	wg.Wait()
+
+	return errors.Join(append([]error{serveErr}, errs...)...)
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same host and path on the
+// TLS listener at addr. Only GET and HEAD are redirected, anything else is
+// refused so credentials sent in the clear aren't silently accepted.
+func redirectToHTTPS(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(res, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" && port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := url.URL{Scheme: "https", Host: host, Path: req.URL.Path, RawQuery: req.URL.RawQuery}
+		http.Redirect(res, req, target.String(), http.StatusPermanentRedirect)
+	})
+}
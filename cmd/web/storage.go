@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"example.com/practice-rest/internal/migrations"
+	"example.com/practice-rest/internal/models"
+	"example.com/practice-rest/internal/passwords"
+	"example.com/practice-rest/internal/sessionstore"
+	"example.com/practice-rest/internal/sqldb"
+	"example.com/practice-rest/pkg/config"
+	"github.com/alexedwards/scs/mysqlstore"
+	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
+	"time"
+)
+
+// storage is everything the application keeps state in.
+type storage struct {
+	// db and migrator are nil when nothing is kept in a database
+	db         *sql.DB
+	dialect    sqldb.Dialect
+	statements *sqldb.StatementCache
+	replicas   *sqldb.Replicas
+	migrator   *migrations.Migrator
+
+	post       models.PostRepository
+	user       models.UserRepository
+	session    models.SessionRepository
+	identity   models.IdentityRepository
+	unitOfWork models.UnitOfWork
+	sessions   scs.Store
+}
+
+// openStorage connects to the database named by cfg.DSN, see sqldb.Open for the
+// forms it takes. The DSN "memory" keeps everything in process instead, which
+// is lost on restart and only suits development and tests.
+func openStorage(cfg config.Database, hasher *passwords.Hasher) (*storage, error) {
+	if cfg.DSN == "memory" {
+		repos := models.Repositories{
+			Posts:      models.NewMemoryPostRepository(),
+			Users:      models.NewMemoryUserRepository(hasher),
+			Sessions:   models.NewMemorySessionRepository(),
+			Identities: models.NewMemoryIdentityRepository(),
+		}
+		return &storage{
+			post:       repos.Posts,
+			user:       repos.Users,
+			session:    repos.Sessions,
+			identity:   repos.Identities,
+			unitOfWork: models.NewMemoryUnitOfWork(repos),
+			sessions:   memstore.New(),
+		}, nil
+	}
+
+	db, dialect, err := sqldb.Open(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	pool := func(db *sql.DB) {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	pool(db)
+	timeout := cfg.QueryTimeout
+
+	var replicas *sqldb.Replicas
+	if len(cfg.Replicas) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		replicas, err = sqldb.OpenReplicas(ctx, cfg.Replicas, dialect)
+		cancel()
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		replicas.SetPool(pool)
+	}
+
+	// SQLite runs in process, so preparing saves it nothing
+	var statements *sqldb.StatementCache
+	if dialect != sqldb.SQLite {
+		statements = sqldb.NewStatementCache(db)
+	}
+
+	// scs ships a store for MySQL, the others share one
+	var sessions scs.Store = sessionstore.New(db, dialect)
+	if dialect == sqldb.MySQL {
+		sessions = mysqlstore.New(db)
+	}
+
+	return &storage{
+		db:         db,
+		dialect:    dialect,
+		statements: statements,
+		replicas:   replicas,
+		migrator:   &migrations.Migrator{DB: db, Dialect: dialect, LockTimeout: migrationLockTimeout},
+		post:       &models.PostModel{DB: db, Dialect: dialect, QueryTimeout: timeout, Statements: statements, Replicas: replicas},
+		user:       &models.UserModel{DB: db, Dialect: dialect, Hasher: hasher, QueryTimeout: timeout, Statements: statements, Replicas: replicas},
+		session:    &models.SessionModel{DB: db, Dialect: dialect, QueryTimeout: timeout},
+		identity:   &models.IdentityModel{DB: db, Dialect: dialect, QueryTimeout: timeout},
+		unitOfWork: &models.SQLUnitOfWork{DB: db, Dialect: dialect, Hasher: hasher, QueryTimeout: timeout},
+		sessions:   sessions,
+	}, nil
+}
+
+// Close stops the session store's cleanup goroutine and closes the database,
+// if there is one. The cleanup is stopped first so it can't run a query
+// against a closed database.
+func (s *storage) Close() error {
+	if stopper, ok := s.sessions.(interface{ StopCleanup() }); ok {
+		stopper.StopCleanup()
+	}
+	if s.db == nil {
+		return nil
+	}
+	if s.statements != nil {
+		s.statements.Close()
+	}
+	if s.replicas != nil {
+		s.replicas.Close()
+	}
+	return s.db.Close()
+}
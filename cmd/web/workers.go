@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"example.com/practice-rest/internal/models"
+	"time"
+)
+
+// Account deletion policies for the posts a deleted user wrote.
+const (
+	deletionAnonymise = "anonymise"
+	deletionDelete    = "delete"
+)
+
+// runDeletionPurger hard deletes accounts whose deletion grace period is over
+// and purges the posts that have been in the trash for longer than the
+// retention window, checking every interval until ctx is cancelled.
+func (app *application) runDeletionPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ids, err := app.user.DueForDeletion(ctx)
+			if err != nil {
+				app.logger.ErrorContext(ctx, "Deletion Purge Failed", "error", err)
+				continue
+			}
+
+			for _, id := range ids {
+				if err = app.purgeUser(ctx, id); err != nil {
+					app.logger.ErrorContext(ctx, "Account Deletion Failed", "id", id, "error", err)
+					continue
+				}
+				app.logger.InfoContext(ctx, "Account Deleted", "id", id)
+			}
+
+			count, err := app.post.PurgeTrashed(ctx, time.Now().Add(-app.trashRetention))
+			if err != nil {
+				app.logger.ErrorContext(ctx, "Trash Purge Failed", "error", err)
+				continue
+			}
+			if count > 0 {
+				app.logger.InfoContext(ctx, "Posts Purged", "count", count)
+			}
+		}
+	}
+}
+
+// purgeUser removes the user and everything that belongs to them, all or
+// nothing. Their posts are anonymised or deleted depending on the configured
+// policy.
+func (app *application) purgeUser(ctx context.Context, id int) error {
+	return app.unitOfWork.Do(ctx, func(ctx context.Context, repos models.Repositories) error {
+		var err error
+		if app.deletionPolicy == deletionDelete {
+			err = repos.Posts.DeleteByUser(ctx, id)
+		} else {
+			err = repos.Posts.AnonymiseByUser(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = repos.Sessions.DeleteByUser(ctx, id); err != nil {
+			return err
+		}
+		if err = repos.Identities.DeleteByUser(ctx, id); err != nil {
+			return err
+		}
+
+		return repos.Users.Delete(ctx, id)
+	})
+}
+
+// runRateLimitSweeper drops rate limit buckets that have been idle for longer
+// than idle, checking every interval until ctx is cancelled.
+func (app *application) runRateLimitSweeper(ctx context.Context, interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.limiter.Store.Sweep(idle); err != nil {
+				app.logger.ErrorContext(ctx, "Rate Limit Sweep Failed", "error", err)
+			}
+		}
+	}
+}
+
+// runCertReloader picks up a renewed TLS certificate, checking the files
+// every interval until ctx is cancelled. A certificate that fails to load is
+// logged and the previous one stays in use.
+func (app *application) runCertReloader(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := app.certs.Reload()
+			if err != nil {
+				app.logger.ErrorContext(ctx, "Certificate Reload Failed", "error", err)
+				continue
+			}
+			if reloaded {
+				app.logger.InfoContext(ctx, "Certificate Reloaded", "file", app.certs.CertFile)
+			}
+		}
+	}
+}
+
+// runReplicaChecker health-checks the read replicas every interval until ctx
+// is cancelled. Reads skip the replicas that fail until they answer again.
+func (app *application) runReplicaChecker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check, cancel := context.WithTimeout(ctx, interval)
+			err := app.replicas.Check(check)
+			cancel()
+			if err != nil {
+				app.logger.WarnContext(ctx, "Replica Unhealthy", "error", err)
+			}
+		}
+	}
+}
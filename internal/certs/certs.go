@@ -0,0 +1,151 @@
+// Package certs provides the server certificate for TLS, either loaded from
+// files that are reloaded when they change or generated for local development.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves the certificate in CertFile and KeyFile through
+// tls.Config.GetCertificate, so a renewed certificate is picked up without
+// restarting the server.
+type Reloader struct {
+	CertFile string
+	KeyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// NewReloader loads the key pair once so a bad certificate fails at startup.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{CertFile: certFile, KeyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the key pair again if either file changed since the last load
+// and reports whether it did. On error the previous certificate stays in use.
+func (r *Reloader) Reload() (bool, error) {
+	modified, err := latestModTime(r.CertFile, r.KeyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && modified.Equal(r.modified)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modified = modified
+	r.mu.Unlock()
+	return true, nil
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func latestModTime(names ...string) (time.Time, error) {
+	var latest time.Time
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// SelfSigned generates a throwaway certificate for hosts, which may be names
+// or IP addresses. It is only meant for local development, clients have to be
+// told to trust it explicitly.
+func SelfSigned(hosts []string) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		return tls.Certificate{}, errors.New("certs: no hosts to certify")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"practice-rest development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(30 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("certs: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("certs: %w", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// ClientCAs reads the PEM certificates client certificates must chain to.
+func ClientCAs(name string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("certs: no certificates found in %s", name)
+	}
+	return pool, nil
+}
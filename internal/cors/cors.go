@@ -0,0 +1,160 @@
+// Package cors implements the server side of Cross-Origin Resource Sharing for
+// a configurable set of origins.
+package cors
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy decides which cross-origin requests browsers are allowed to make.
+type Policy struct {
+	// AllowedOrigins are matched against the Origin header. An entry is either
+	// an exact origin ("https://app.example.com"), a wildcard subdomain
+	// ("https://*.example.com") or "*" for any origin. Origins only allowed by
+	// "*" never get credentials, whatever AllowCredentials says.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// AllowOrigin reports whether origin matches one of the allowed origins.
+func (p *Policy) AllowOrigin(origin string) bool {
+	return origin != "" && (slices.Contains(p.AllowedOrigins, "*") || p.listed(origin))
+}
+
+// listed reports whether origin matches an allowed origin other than "*".
+func (p *Policy) listed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			continue
+		}
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		prefix, suffix, wildcard := strings.Cut(allowed, "*")
+		if wildcard && len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+			strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) {
+			// The wildcard stands for subdomain labels, never for a path or port
+			sub := origin[len(prefix) : len(origin)-len(suffix)]
+			if !strings.ContainsAny(sub, "/:") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *Policy) allowMethod(method string) bool {
+	return slices.ContainsFunc(p.AllowedMethods, func(allowed string) bool {
+		return strings.EqualFold(allowed, method)
+	})
+}
+
+func (p *Policy) allowHeaders(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		if !slices.ContainsFunc(p.AllowedHeaders, func(allowed string) bool {
+			return allowed == "*" || strings.EqualFold(allowed, header)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin writes the headers shared by preflight and actual responses.
+// Credentials are only allowed for origins that are listed explicitly, with
+// the origin echoed since browsers refuse the wildcard for credentialed
+// requests. Echoing every origin with credentials would let any site read the
+// responses of a logged-in user, so origins only allowed by "*" get "*".
+func (p *Policy) setOrigin(header http.Header, origin string) {
+	switch {
+	case p.AllowCredentials && p.listed(origin):
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+	case slices.Contains(p.AllowedOrigins, "*"):
+		header.Set("Access-Control-Allow-Origin", "*")
+	default:
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+// Handler adds CORS headers to actual (non-preflight) cross-origin requests.
+// Preflight requests are passed through untouched for Preflight to answer.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		// The response differs per origin, so caches have to key on it even
+		// when this particular origin is rejected.
+		res.Header().Add("Vary", "Origin")
+
+		origin := req.Header.Get("Origin")
+		if isPreflight(req) || !p.AllowOrigin(origin) {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		p.setOrigin(res.Header(), origin)
+		if len(p.ExposedHeaders) > 0 {
+			res.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// Preflight answers OPTIONS requests. It is meant to be installed as the
+// router's global OPTIONS handler, which only runs for paths that exist.
+func (p *Policy) Preflight(res http.ResponseWriter, req *http.Request) {
+	if !isPreflight(req) {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	header := res.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	origin := req.Header.Get("Origin")
+	method := req.Header.Get("Access-Control-Request-Method")
+	requested := req.Header.Get("Access-Control-Request-Headers")
+
+	// A rejected preflight is answered without CORS headers, which is how the
+	// browser learns it must not send the actual request.
+	if !p.AllowOrigin(origin) || !p.allowMethod(method) || !p.allowHeaders(requested) {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	p.setOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+	if requested != "" {
+		header.Set("Access-Control-Allow-Headers", requested)
+	}
+	if p.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+}
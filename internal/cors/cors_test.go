@@ -0,0 +1,43 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		origin      string
+		wantOrigin  string
+		wantCreds   string
+	}{
+		{"listed origin", []string{"https://app.example.com"}, true, "https://app.example.com", "https://app.example.com", "true"},
+		{"wildcard subdomain", []string{"https://*.example.com"}, true, "https://app.example.com", "https://app.example.com", "true"},
+		{"any origin without credentials", []string{"*"}, false, "https://evil.example", "*", ""},
+		{"any origin never gets credentials", []string{"*"}, true, "https://evil.example", "*", ""},
+		{"listed origin next to any origin", []string{"*", "https://app.example.com"}, true, "https://app.example.com", "https://app.example.com", "true"},
+		{"unlisted origin", []string{"https://app.example.com"}, true, "https://evil.example", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Policy{AllowedOrigins: tt.origins, AllowCredentials: tt.credentials}
+			handler := p.Handler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, "/csrf", nil)
+			req.Header.Set("Origin", tt.origin)
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			if got := res.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := res.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCreds)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Package health runs the readiness checks behind /readyz.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check reports whether a dependency is usable. It must give up when ctx is done.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of a single check.
+type Result struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report is the outcome of a readiness run.
+type Report struct {
+	Ready    bool              `json:"ready"`
+	Draining bool              `json:"draining,omitempty"`
+	Checks   map[string]Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Checker struct {
+	// Timeout bounds every check.
+	Timeout time.Duration
+
+	mu       sync.RWMutex
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Drain makes every following run report not ready, so load balancers stop
+// sending traffic while in-flight requests finish.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Run executes all checks concurrently.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func(i int, nc namedCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+
+			// Not every dependency's client honours contexts, so a check that
+			// hangs is abandoned rather than holding up the whole probe.
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() { done <- nc.check(checkCtx) }()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-checkCtx.Done():
+				err = checkCtx.Err()
+			}
+
+			results[i] = Result{Status: "ok", Latency: time.Since(start).String()}
+			if err != nil {
+				results[i].Status = "failed"
+				results[i].Error = err.Error()
+			}
+		}(i, nc)
+	}
+	wg.Wait()
+
+	report := Report{Ready: true, Checks: make(map[string]Result, len(checks))}
+	for i, nc := range checks {
+		report.Checks[nc.name] = results[i]
+		if results[i].Status != "ok" {
+			report.Ready = false
+		}
+	}
+
+	if c.draining.Load() {
+		report.Ready = false
+		report.Draining = true
+	}
+
+	return report
+}
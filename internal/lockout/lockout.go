@@ -0,0 +1,159 @@
+package lockout
+
+import (
+	"strings"
+	"time"
+)
+
+// Event kinds recorded in the audit trail.
+const (
+	EventFailure = "failure"
+	EventLocked  = "locked"
+	EventBlocked = "blocked"
+	EventSuccess = "success"
+)
+
+// Policy describes how aggressively failed logins for a single key are throttled.
+type Policy struct {
+	// Threshold is the number of failures tolerated before the key is locked.
+	Threshold int
+	// BaseDelay is the lockout applied once Threshold is reached. Every further
+	// failure doubles it, capped at MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long a failure is remembered after the last one.
+	Window time.Duration
+}
+
+// Attempts is the failure counter stored for a single key.
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Event is a single entry of the login audit trail.
+type Event struct {
+	Kind  string    `json:"kind"`
+	Email string    `json:"email"`
+	IP    string    `json:"ip"`
+	At    time.Time `json:"at"`
+}
+
+// Store keeps the failure counters and the audit trail. Implementations must be
+// safe for concurrent use.
+type Store interface {
+	// Get returns the counter for key, or a zero Attempts if there is none.
+	Get(key string) (Attempts, error)
+	// AddFailure increments the counter for key, starting over from one when the
+	// previous failure is older than window, and returns the updated counter.
+	AddFailure(key string, at time.Time, window time.Duration) (Attempts, error)
+	// Reset forgets the counter for key.
+	Reset(key string) error
+	// Audit records an event.
+	Audit(event Event) error
+}
+
+// RetryAfter returns how long the caller must wait before another attempt is
+// allowed, or zero if the key is not locked at now.
+func (p Policy) RetryAfter(a Attempts, now time.Time) time.Duration {
+	if p.Threshold <= 0 || a.Failures < p.Threshold {
+		return 0
+	}
+	if p.Window > 0 && now.Sub(a.LastFailure) > p.Window {
+		return 0
+	}
+
+	delay := p.MaxDelay
+	if shift := a.Failures - p.Threshold; shift < 32 {
+		if d := p.BaseDelay << shift; d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+
+	until := a.LastFailure.Add(delay)
+	if !now.Before(until) {
+		return 0
+	}
+	return until.Sub(now)
+}
+
+// Guard applies separate policies to the account being logged into and to the
+// IP address the attempt comes from.
+type Guard struct {
+	Store   Store
+	Account Policy
+	IP      Policy
+
+	// Now is used instead of time.Now when set.
+	Now func() time.Time
+}
+
+func (g *Guard) now() time.Time {
+	if g.Now != nil {
+		return g.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns how long the caller must wait before trying to log in to email
+// from ip. A blocked attempt is recorded in the audit trail.
+func (g *Guard) Check(email, ip string) (time.Duration, error) {
+	now := g.now()
+
+	account, err := g.Store.Get(accountKey(email))
+	if err != nil {
+		return 0, err
+	}
+	address, err := g.Store.Get(ipKey(ip))
+	if err != nil {
+		return 0, err
+	}
+
+	wait := max(g.Account.RetryAfter(account, now), g.IP.RetryAfter(address, now))
+	if wait > 0 {
+		err = g.Store.Audit(Event{Kind: EventBlocked, Email: email, IP: ip, At: now})
+	}
+	return wait, err
+}
+
+// Fail records a failed login and returns the lockout it caused, if any.
+func (g *Guard) Fail(email, ip string) (time.Duration, error) {
+	now := g.now()
+
+	account, err := g.Store.AddFailure(accountKey(email), now, g.Account.Window)
+	if err != nil {
+		return 0, err
+	}
+	address, err := g.Store.AddFailure(ipKey(ip), now, g.IP.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = g.Store.Audit(Event{Kind: EventFailure, Email: email, IP: ip, At: now}); err != nil {
+		return 0, err
+	}
+
+	wait := max(g.Account.RetryAfter(account, now), g.IP.RetryAfter(address, now))
+	if wait > 0 {
+		err = g.Store.Audit(Event{Kind: EventLocked, Email: email, IP: ip, At: now})
+	}
+	return wait, err
+}
+
+// Succeed clears the account counter after a successful login. The IP counter
+// is left alone so that logging in to one's own account can't be used to reset
+// it while guessing passwords for someone else's.
+func (g *Guard) Succeed(email, ip string) error {
+	if err := g.Store.Reset(accountKey(email)); err != nil {
+		return err
+	}
+	return g.Store.Audit(Event{Kind: EventSuccess, Email: email, IP: ip, At: g.now()})
+}
@@ -0,0 +1,127 @@
+package lockout
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Guard.Now that only moves when told to.
+type fakeClock struct{ now time.Time }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func policy(threshold int) Policy {
+	return Policy{Threshold: threshold, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute, Window: time.Hour}
+}
+
+func newGuard(clock *fakeClock, store Store) *Guard {
+	return &Guard{Store: store, Account: policy(3), IP: policy(10), Now: clock.Now}
+}
+
+func TestRetryAfter(t *testing.T) {
+	p := policy(3)
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		failures int
+		now      time.Time
+		want     time.Duration
+	}{
+		{"below threshold", 2, last, 0},
+		{"at threshold", 3, last, time.Minute},
+		{"doubles per failure", 5, last, 4 * time.Minute},
+		{"capped", 20, last, 10 * time.Minute},
+		{"partly waited", 3, last.Add(20 * time.Second), 40 * time.Second},
+		{"waited out", 3, last.Add(time.Minute), 0},
+		{"outside window", 20, last.Add(2 * time.Hour), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := p.RetryAfter(Attempts{Failures: tt.failures, LastFailure: last}, tt.now)
+			if got != tt.want {
+				t.Errorf("RetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuardLocksAndUnlocks(t *testing.T) {
+	clock := newFakeClock()
+	g := newGuard(clock, NewMemoryStore(nil))
+
+	for i := 1; i < 3; i++ {
+		wait, err := g.Fail("a@example.com", "10.0.0.1")
+		if err != nil || wait != 0 {
+			t.Fatalf("failure %d: wait = %v, err = %v, want no lockout", i, wait, err)
+		}
+	}
+
+	wait, err := g.Fail("A@Example.com ", "10.0.0.1")
+	if err != nil || wait != time.Minute {
+		t.Fatalf("third failure: wait = %v, err = %v, want 1m", wait, err)
+	}
+
+	clock.Advance(30 * time.Second)
+	if wait, _ = g.Check("a@example.com", "10.0.0.2"); wait != 30*time.Second {
+		t.Errorf("Check during lockout = %v, want 30s", wait)
+	}
+
+	clock.Advance(30 * time.Second)
+	if wait, _ = g.Check("a@example.com", "10.0.0.2"); wait != 0 {
+		t.Errorf("Check after lockout = %v, want 0", wait)
+	}
+}
+
+func TestGuardForgetsOldFailures(t *testing.T) {
+	clock := newFakeClock()
+	g := newGuard(clock, NewMemoryStore(nil))
+
+	g.Fail("a@example.com", "10.0.0.1")
+	g.Fail("a@example.com", "10.0.0.1")
+	clock.Advance(2 * time.Hour)
+
+	if wait, _ := g.Fail("a@example.com", "10.0.0.1"); wait != 0 {
+		t.Errorf("failure after the window = %v, want the count to start over", wait)
+	}
+}
+
+func TestGuardSucceedKeepsIPCounter(t *testing.T) {
+	clock := newFakeClock()
+	g := newGuard(clock, NewMemoryStore(nil))
+	g.IP = policy(2)
+
+	g.Fail("victim@example.com", "10.0.0.1")
+	if err := g.Succeed("attacker@example.com", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if wait, _ := g.Fail("victim@example.com", "10.0.0.1"); wait != time.Minute {
+		t.Errorf("IP lockout = %v, want 1m: a success must not reset the IP counter", wait)
+	}
+}
+
+func TestMemoryStoreLogsAuditEvents(t *testing.T) {
+	var buf bytes.Buffer
+	clock := newFakeClock()
+	g := newGuard(clock, NewMemoryStore(slog.New(slog.NewTextHandler(&buf, nil))))
+
+	g.Fail("a@example.com", "10.0.0.1")
+	g.Succeed("a@example.com", "10.0.0.1")
+
+	out := buf.String()
+	for _, want := range []string{"kind=failure", "kind=success", "email=a@example.com", "ip=10.0.0.1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log %q doesn't contain %q", out, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package lockout
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in process memory. It is meant for single-node
+// deployments and local development; counters are lost on restart. There is
+// no table to keep the audit trail in, so events are written to Logger.
+type MemoryStore struct {
+	Logger *slog.Logger
+
+	mu        sync.Mutex
+	attempts  map[string]Attempts
+	lastSweep time.Time
+}
+
+func NewMemoryStore(logger *slog.Logger) *MemoryStore {
+	return &MemoryStore{Logger: logger, attempts: make(map[string]Attempts)}
+}
+
+func (m *MemoryStore) Get(key string) (Attempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.attempts[key], nil
+}
+
+func (m *MemoryStore) AddFailure(key string, at time.Time, window time.Duration) (Attempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(at, window)
+
+	a := m.attempts[key]
+	if window > 0 && at.Sub(a.LastFailure) > window {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailure = at
+	m.attempts[key] = a
+
+	return a, nil
+}
+
+func (m *MemoryStore) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *MemoryStore) Audit(event Event) error {
+	if m.Logger != nil {
+		m.Logger.LogAttrs(context.Background(), slog.LevelInfo, "Login Event",
+			slog.String("kind", event.Kind),
+			slog.String("email", event.Email),
+			slog.String("ip", event.IP),
+			slog.Time("at", event.At),
+		)
+	}
+	return nil
+}
+
+// sweep drops counters that have outlived window, at most once per window so
+// the map can't grow without bound. Must be called with mu held.
+func (m *MemoryStore) sweep(now time.Time, window time.Duration) {
+	if window <= 0 || now.Sub(m.lastSweep) < window {
+		return
+	}
+	for key, a := range m.attempts {
+		if now.Sub(a.LastFailure) > window {
+			delete(m.attempts, key)
+		}
+	}
+	m.lastSweep = now
+}
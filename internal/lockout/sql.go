@@ -0,0 +1,70 @@
+package lockout
+
+import (
+	"database/sql"
+	"errors"
+	"example.com/practice-rest/internal/sqldb"
+	"time"
+)
+
+// SQLStore keeps counters in the database so that every instance behind a load
+// balancer sees the same attempts. Its tables are created by the
+// 0006_create_login_attempts migration.
+type SQLStore struct {
+	DB      *sql.DB
+	Dialect sqldb.Dialect
+}
+
+func (m *SQLStore) Get(key string) (Attempts, error) {
+	query := `select failures, last_failure from login_attempts where attempt_key = ?`
+
+	var a Attempts
+	err := m.DB.QueryRow(m.Dialect.Rebind(query), key).Scan(&a.Failures, &a.LastFailure)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Attempts{}, err
+	}
+	return a, nil
+}
+
+func (m *SQLStore) AddFailure(key string, at time.Time, window time.Duration) (Attempts, error) {
+	// The counter starts over when the previous failure fell out of the window.
+	// MySQL evaluates the assignments left to right, so failures still sees the
+	// old last_failure.
+	query := `insert into login_attempts (attempt_key, failures, last_failure) values (?, 1, ?)
+			  on duplicate key update
+			  failures = if(last_failure < ?, 1, failures + 1),
+			  last_failure = values(last_failure)`
+	// Postgres and SQLite evaluate every assignment against the old row
+	if m.Dialect == sqldb.Postgres || m.Dialect == sqldb.SQLite {
+		query = `insert into login_attempts (attempt_key, failures, last_failure) values (?, 1, ?)
+				 on conflict (attempt_key) do update set
+				 failures = case when login_attempts.last_failure < ? then 1 else login_attempts.failures + 1 end,
+				 last_failure = excluded.last_failure`
+	}
+
+	cutoff := time.Unix(0, 0).UTC()
+	if window > 0 {
+		cutoff = at.Add(-window)
+	}
+
+	_, err := m.DB.Exec(m.Dialect.Rebind(query), key, at.UTC(), cutoff.UTC())
+	if err != nil {
+		return Attempts{}, err
+	}
+
+	return m.Get(key)
+}
+
+func (m *SQLStore) Reset(key string) error {
+	query := `delete from login_attempts where attempt_key = ?`
+
+	_, err := m.DB.Exec(m.Dialect.Rebind(query), key)
+	return err
+}
+
+func (m *SQLStore) Audit(event Event) error {
+	query := `insert into login_events (kind, email, ip, created) values (?, ?, ?, ?)`
+
+	_, err := m.DB.Exec(m.Dialect.Rebind(query), event.Kind, event.Email, event.IP, event.At.UTC())
+	return err
+}
@@ -0,0 +1,102 @@
+// Package logging sets up structured logging with log/slog and carries
+// per-request fields through the context so every line logged while handling a
+// request can be correlated with it.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+type contextKey struct{}
+
+// request holds the fields added to every line logged with the request's
+// context. The user is filled in once the session has been loaded, which is
+// after the context was created, hence the atomic.
+type request struct {
+	id     string
+	userID atomic.Int64
+}
+
+// WithRequestID returns a context whose log lines carry id as request_id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &request{id: id})
+}
+
+// RequestID returns the id set by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+	if r, ok := ctx.Value(contextKey{}).(*request); ok {
+		return r.id
+	}
+	return ""
+}
+
+// SetUserID adds user_id to the lines logged with ctx, including by code that
+// only sees a parent of ctx, as long as it descends from WithRequestID.
+func SetUserID(ctx context.Context, userID int) {
+	if r, ok := ctx.Value(contextKey{}).(*request); ok {
+		r.userID.Store(int64(userID))
+	}
+}
+
+// UserID returns the id set by SetUserID, or zero.
+func UserID(ctx context.Context) int {
+	if r, ok := ctx.Value(contextKey{}).(*request); ok {
+		return int(r.userID.Load())
+	}
+	return 0
+}
+
+// contextHandler adds the request fields found in the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	if r, ok := ctx.Value(contextKey{}).(*request); ok {
+		record.AddAttrs(slog.String("request_id", r.id))
+		if userID := r.userID.Load(); userID != 0 {
+			record.AddAttrs(slog.Int64("user_id", userID))
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// New returns a logger writing to w in format ("json" or "text") at level
+// ("debug", "info", "warn" or "error").
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("logging: %w", err)
+	}
+
+	options := &slog.HandlerOptions{Level: lvl, AddSource: true}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
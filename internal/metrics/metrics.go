@@ -0,0 +1,148 @@
+// Package metrics exposes HTTP, database pool, session store and Go runtime
+// metrics in the Prometheus text format.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// unrouted labels requests that never reached a route, e.g. 404s or requests
+// rejected by middleware in front of the router. Using raw paths instead would
+// give every scanner probe its own time series.
+const unrouted = "unrouted"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+
+	sessionOps      *prometheus.CounterVec
+	sessionDuration *prometheus.HistogramVec
+}
+
+// New registers the metrics, including the pool statistics of db under dbName
+// unless db is nil.
+func New(db *sql.DB, dbName string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests handled, by route pattern and status.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency, by route pattern and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "HTTP requests currently being handled.",
+		}),
+		sessionOps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "session_store_operations_total",
+			Help: "Session store operations, by operation and result.",
+		}, []string{"operation", "result"}),
+		sessionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "session_store_operation_duration_seconds",
+			Help:    "Session store operation latency, by operation.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.duration, m.inFlight, m.sessionOps, m.sessionDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, dbName))
+	}
+
+	return m
+}
+
+// Handler serves the metrics. It belongs on the admin listener, not the API.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+type routeKey struct{}
+
+// Middleware records every request. It should wrap the router so that requests
+// rejected before routing are counted too; the route label is filled in by
+// Route.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		route := unrouted
+		req = req.WithContext(context.WithValue(req.Context(), routeKey{}, &route))
+		recorder := &statusRecorder{ResponseWriter: res}
+		start := time.Now()
+
+		defer func() {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			labels := prometheus.Labels{"method": method(req.Method), "route": route, "status": strconv.Itoa(status)}
+			m.requests.With(labels).Inc()
+			m.duration.With(labels).Observe(time.Since(start).Seconds())
+		}()
+
+		next.ServeHTTP(recorder, req)
+	})
+}
+
+// Route labels requests handled by next with the router pattern they matched.
+func (m *Metrics) Route(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if route, ok := req.Context().Value(routeKey{}).(*string); ok {
+			*route = pattern
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
+// method keeps made-up request methods from creating new time series.
+func method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return m
+	}
+	return "OTHER"
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
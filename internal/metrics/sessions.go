@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"github.com/alexedwards/scs/v2"
+	"time"
+)
+
+// sessionStore wraps an scs store to count and time its operations.
+type sessionStore struct {
+	scs.Store
+	metrics *Metrics
+}
+
+// SessionStore instruments store.
+func (m *Metrics) SessionStore(store scs.Store) scs.Store {
+	return &sessionStore{Store: store, metrics: m}
+}
+
+func (s *sessionStore) observe(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	s.metrics.sessionOps.WithLabelValues(operation, result).Inc()
+	s.metrics.sessionDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func (s *sessionStore) Delete(token string) error {
+	start := time.Now()
+	err := s.Store.Delete(token)
+	s.observe("delete", start, err)
+	return err
+}
+
+func (s *sessionStore) Find(token string) ([]byte, bool, error) {
+	start := time.Now()
+	b, found, err := s.Store.Find(token)
+	s.observe("find", start, err)
+	return b, found, err
+}
+
+func (s *sessionStore) Commit(token string, b []byte, expiry time.Time) error {
+	start := time.Now()
+	err := s.Store.Commit(token, b, expiry)
+	s.observe("commit", start, err)
+	return err
+}
@@ -0,0 +1,495 @@
+// Package migrations keeps the database schema in versioned SQL files that are
+// embedded in the binary and applied in order.
+//
+// Each migration is a pair of files named VERSION_NAME.up.sql and
+// VERSION_NAME.down.sql. Statements in a file are separated by a semicolon at
+// the end of a line. Applied migrations are recorded in the migrations table
+// together with a checksum of their up file, so a migration edited after it
+// ran is reported rather than silently diverging from the database.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"example.com/practice-rest/internal/sqldb"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// Every dialect has its own directory of migrations, with the same versions
+// and names in each.
+var sources = map[sqldb.Dialect]fs.FS{
+	sqldb.MySQL:    mustSub(files, "mysql"),
+	sqldb.Postgres: mustSub(files, "postgres"),
+	sqldb.SQLite:   mustSub(files, "sqlite"),
+}
+
+// For returns the migrations written for dialect.
+func For(dialect sqldb.Dialect) fs.FS {
+	return sources[sqldb.Dialect(dialect.String())]
+}
+
+// lockName is the named lock held while migrating, so two instances starting
+// at once don't apply the same migration twice. Postgres advisory locks take a
+// number instead, lockKey is lockName's.
+const (
+	lockName = "practice-rest:migrations"
+	lockKey  = 7_384_923_108
+)
+
+var (
+	ErrModified     = errors.New("migrations: applied migration was modified")
+	ErrUnknown      = errors.New("migrations: database has migrations this binary doesn't know")
+	ErrIrreversible = errors.New("migrations: migration has no down file")
+	ErrLocked       = errors.New("migrations: timed out waiting for the migration lock")
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status is a migration together with whether and when it was applied.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	// Modified is set when the up file changed after it was applied.
+	Modified bool
+}
+
+// Load reads the migrations in source, ordered by version.
+func Load(source fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	hasUp := map[int64]bool{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		content, err := fs.ReadFile(source, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations: version %d is used by both %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			hasUp[version] = true
+			sum := sha256.Sum256(content)
+			m.Up = string(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if !hasUp[m.Version] {
+			return nil, fmt.Errorf("migrations: %04d_%s has no up file", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Migrator applies the migrations for Dialect to DB, or those in Source if
+// set. Every operation holds a database-wide lock for its duration, waiting up
+// to LockTimeout for it.
+type Migrator struct {
+	DB          *sql.DB
+	Dialect     sqldb.Dialect
+	Source      fs.FS
+	LockTimeout time.Duration
+}
+
+func (m *Migrator) source() fs.FS {
+	if m.Source != nil {
+		return m.Source
+	}
+	return For(m.Dialect)
+}
+
+type applied struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// Up applies every pending migration in order and returns the ones it applied.
+// It refuses to run while an applied migration has been modified.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, migrations []Migration, history map[int64]applied) error {
+		if err := check(migrations, history); err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if _, ok := history[migration.Version]; ok {
+				continue
+			}
+			if err := run(ctx, conn, migration.Up); err != nil {
+				return fmt.Errorf("migrations: %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			query := `insert into migrations (version, name, checksum, applied_at) values (?, ?, ?, ?)`
+			_, err := conn.ExecContext(ctx, m.Dialect.Rebind(query), migration.Version, migration.Name, migration.Checksum, time.Now().UTC().Truncate(time.Second))
+			if err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the latest steps applied migrations, newest first, and returns
+// the ones it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, migrations []Migration, history map[int64]applied) error {
+		if err := check(migrations, history); err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := history[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("%w: %04d_%s", ErrIrreversible, migration.Version, migration.Name)
+			}
+			if err := run(ctx, conn, migration.Down); err != nil {
+				return fmt.Errorf("migrations: %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			query := `delete from migrations where version = ?`
+			if _, err := conn.ExecContext(ctx, m.Dialect.Rebind(query), migration.Version); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every known migration and whether it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var list []Status
+	err := m.locked(ctx, func(conn *sql.Conn, migrations []Migration, history map[int64]applied) error {
+		for _, migration := range migrations {
+			status := Status{Migration: migration}
+			if a, ok := history[migration.Version]; ok {
+				appliedAt := a.appliedAt
+				status.AppliedAt = &appliedAt
+				status.Modified = a.checksum != migration.Checksum
+			}
+			list = append(list, status)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// Pending returns how many migrations haven't been applied yet, all of them
+// on a database that was never migrated. It doesn't take the lock, so it's
+// cheap enough for a readiness check.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	migrations, err := Load(m.source())
+	if err != nil {
+		return 0, err
+	}
+
+	exists, err := m.tableExists(ctx)
+	if err != nil || !exists {
+		return len(migrations), err
+	}
+
+	var count int
+	err = m.DB.QueryRowContext(ctx, `select count(*) from migrations`).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return len(migrations) - count, nil
+}
+
+// tableExists reports whether the migrations table has been created.
+func (m *Migrator) tableExists(ctx context.Context) (bool, error) {
+	var query string
+	switch m.Dialect {
+	case sqldb.SQLite:
+		query = `select count(*) from sqlite_master where type = 'table' and name = 'migrations'`
+	case sqldb.Postgres:
+		query = `select count(*) from information_schema.tables
+				 where table_schema = current_schema() and table_name = 'migrations'`
+	default:
+		query = `select count(*) from information_schema.tables
+				 where table_schema = database() and table_name = 'migrations'`
+	}
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count)
+	return count > 0, err
+}
+
+// locked runs fn on a single connection holding the migration lock, with the
+// migrations table created and its contents loaded.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, migrations []Migration, history map[int64]applied) error) error {
+	migrations, err := Load(m.source())
+	if err != nil {
+		return err
+	}
+
+	// Named and advisory locks belong to the connection, so everything runs
+	// on this one
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := m.lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	column := "datetime"
+	if m.Dialect == sqldb.Postgres {
+		column = "timestamp"
+	}
+	query := `create table if not exists migrations (
+				version    bigint not null primary key,
+				name       varchar(255) not null,
+				checksum   char(64) not null,
+				applied_at ` + column + ` not null
+			  )`
+	if _, err = conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, `select version, checksum, applied_at from migrations`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	history := map[int64]applied{}
+	for rows.Next() {
+		var version int64
+		var a applied
+		if err = rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return err
+		}
+		history[version] = a
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	return fn(conn, migrations, history)
+}
+
+// lock takes the migration lock on conn and returns the func releasing it.
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	switch m.Dialect {
+	case sqldb.SQLite:
+		// SQLite has no named locks, and a database file belongs to a single
+		// instance anyway. Concurrent writers still wait on the busy timeout.
+		return func() {}, nil
+	case sqldb.Postgres:
+		// pg_advisory_lock can't time out by itself, so poll the non-blocking one
+		deadline := time.Now().Add(m.LockTimeout)
+		for {
+			var acquired bool
+			if err := conn.QueryRowContext(ctx, `select pg_try_advisory_lock($1)`, lockKey).Scan(&acquired); err != nil {
+				return nil, err
+			}
+			if acquired {
+				break
+			}
+			if time.Now().After(deadline) {
+				return nil, ErrLocked
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+		return func() { conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockKey) }, nil
+	}
+
+	var acquired sql.NullInt64
+	err := conn.QueryRowContext(ctx, `select get_lock(?, ?)`, lockName, int(m.LockTimeout.Seconds())).Scan(&acquired)
+	if err != nil {
+		return nil, err
+	}
+	if acquired.Int64 != 1 {
+		return nil, ErrLocked
+	}
+	return func() { conn.ExecContext(context.Background(), `select release_lock(?)`, lockName) }, nil
+}
+
+// check refuses to work on a database whose history doesn't match the files.
+func check(migrations []Migration, history map[int64]applied) error {
+	known := map[int64]bool{}
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		if a, ok := history[migration.Version]; ok && a.checksum != migration.Checksum {
+			return fmt.Errorf("%w: %04d_%s", ErrModified, migration.Version, migration.Name)
+		}
+	}
+	for version := range history {
+		if !known[version] {
+			return fmt.Errorf("%w: version %d", ErrUnknown, version)
+		}
+	}
+	return nil
+}
+
+// run executes the statements in a migration file one at a time, the MySQL
+// driver only accepts several per call with multiStatements enabled.
+func run(ctx context.Context, conn *sql.Conn, script string) error {
+	for _, statement := range statements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func statements(script string) []string {
+	var list []string
+	var current strings.Builder
+	hasCode := false
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		current.WriteString(line)
+		current.WriteByte('\n')
+		if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+			hasCode = true
+		}
+
+		if strings.HasSuffix(trimmed, ";") {
+			if hasCode {
+				list = append(list, strings.TrimSpace(current.String()))
+			}
+			current.Reset()
+			hasCode = false
+		}
+	}
+	if hasCode {
+		list = append(list, strings.TrimSpace(current.String()))
+	}
+	return list
+}
+
+// Create writes an empty up and down file for a new migration to every
+// dialect's directory under root, numbered after the newest migration in any
+// of them, and returns their paths.
+func Create(root, name string) ([]string, error) {
+	name = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "_"))
+	if !regexp.MustCompile(`^[a-z0-9_]+$`).MatchString(name) {
+		return nil, fmt.Errorf("migrations: name %q may only contain letters, digits and underscores", name)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	var version int64 = 1
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		migrations, err := Load(os.DirFS(dir))
+		if err != nil {
+			return nil, fmt.Errorf("migrations: reading %s: %w", dir, err)
+		}
+		if n := len(migrations); n > 0 && migrations[n-1].Version >= version {
+			version = migrations[n-1].Version + 1
+		}
+		dirs = append(dirs, dir)
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("migrations: no dialect directories in %s", root)
+	}
+
+	var created []string
+	for _, dir := range dirs {
+		base := filepath.Join(dir, fmt.Sprintf("%04d_%s", version, name))
+		if err := createFiles(base+".up.sql", base+".down.sql"); err != nil {
+			return created, err
+		}
+		created = append(created, base+".up.sql", base+".down.sql")
+	}
+	return created, nil
+}
+
+func createFiles(files ...string) error {
+	for _, file := range files {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString("-- Statements end with a semicolon at the end of a line\n")
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
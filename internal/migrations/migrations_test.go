@@ -0,0 +1,105 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"example.com/practice-rest/internal/sqldb"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func newMigrator(t *testing.T) *Migrator {
+	db, dialect, err := sqldb.Open("sqlite:" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &Migrator{DB: db, Dialect: dialect}
+}
+
+func TestPendingOnFreshDatabase(t *testing.T) {
+	ctx := context.Background()
+	m := newMigrator(t)
+
+	all, err := Load(For(sqldb.SQLite))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		t.Fatalf("Pending() on a fresh database: %v", err)
+	}
+	if pending != len(all) {
+		t.Errorf("Pending() = %d, want all %d", pending, len(all))
+	}
+
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err = m.Pending(ctx); err != nil || pending != 0 {
+		t.Errorf("Pending() after Up = %d, %v, want 0", pending, err)
+	}
+}
+
+func TestUpDownUp(t *testing.T) {
+	ctx := context.Background()
+	m := newMigrator(t)
+
+	all, err := Load(For(sqldb.SQLite))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := m.Down(ctx, len(all))
+	if err != nil {
+		t.Fatalf("Down(): %v", err)
+	}
+	if len(reverted) != len(all) {
+		t.Errorf("Down() reverted %d migrations, want %d", len(reverted), len(all))
+	}
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatalf("Up() after Down(): %v", err)
+	}
+}
+
+func TestModifiedMigration(t *testing.T) {
+	ctx := context.Background()
+	m := newMigrator(t)
+	m.Source = fstest.MapFS{
+		"0001_create_things.up.sql":   {Data: []byte("create table things (id integer primary key);\n")},
+		"0001_create_things.down.sql": {Data: []byte("drop table things;\n")},
+	}
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Source = fstest.MapFS{
+		"0001_create_things.up.sql": {Data: []byte("create table things (id integer primary key, name text);\n")},
+	}
+	if _, err := m.Up(ctx); !errors.Is(err, ErrModified) {
+		t.Errorf("Up() with an edited migration = %v, want ErrModified", err)
+	}
+}
+
+func TestStatements(t *testing.T) {
+	script := `-- a comment on its own
+create table a (
+	-- a comment inside
+	id int
+);
+
+insert into a values (1);
+-- trailing comment;
+`
+	got := statements(script)
+	if len(got) != 2 {
+		t.Fatalf("statements() = %q, want 2 statements", got)
+	}
+}
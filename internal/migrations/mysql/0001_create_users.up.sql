@@ -0,0 +1,11 @@
+create table users (
+	id              int not null primary key auto_increment,
+	name            varchar(255) not null,
+	email           varchar(255) not null,
+	-- NULL for accounts that only sign in through an identity provider
+	hashed_password varchar(255) null,
+	created_at      datetime not null,
+	deletion_due    datetime null,
+	constraint uq_users_email unique (email),
+	index idx_users_deletion_due (deletion_due)
+);
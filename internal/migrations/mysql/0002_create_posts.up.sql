@@ -0,0 +1,11 @@
+create table posts (
+	id      int not null primary key auto_increment,
+	title   varchar(100) not null,
+	content text not null,
+	created datetime not null,
+	expires datetime not null,
+	-- NULL for anonymous and anonymised posts
+	user_id int null,
+	index idx_posts_created (created),
+	index idx_posts_user_id (user_id)
+);
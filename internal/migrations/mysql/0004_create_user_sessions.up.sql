@@ -0,0 +1,10 @@
+create table user_sessions (
+	id         int not null primary key auto_increment,
+	user_id    int not null,
+	token      char(43) not null unique,
+	created    datetime not null,
+	last_seen  datetime not null,
+	ip         varchar(45) not null,
+	user_agent varchar(255) not null,
+	index idx_user_sessions_user_id (user_id)
+);
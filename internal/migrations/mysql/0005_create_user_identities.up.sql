@@ -0,0 +1,10 @@
+create table user_identities (
+	id       int not null primary key auto_increment,
+	user_id  int not null,
+	provider varchar(64) not null,
+	subject  varchar(255) not null,
+	email    varchar(255) not null,
+	created  datetime not null,
+	unique key uq_user_identities_subject (provider, subject),
+	index idx_user_identities_user_id (user_id)
+);
@@ -0,0 +1,3 @@
+drop table login_events;
+
+drop table login_attempts;
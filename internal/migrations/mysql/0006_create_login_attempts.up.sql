@@ -0,0 +1,14 @@
+create table login_attempts (
+	attempt_key  varchar(320) not null primary key,
+	failures     int not null,
+	last_failure datetime not null
+);
+
+create table login_events (
+	id      bigint not null primary key auto_increment,
+	kind    varchar(16) not null,
+	email   varchar(255) not null,
+	ip      varchar(45) not null,
+	created datetime not null,
+	index idx_login_events_email (email)
+);
@@ -0,0 +1 @@
+drop table rate_limits;
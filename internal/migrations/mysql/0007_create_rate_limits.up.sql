@@ -0,0 +1,6 @@
+create table rate_limits (
+	bucket_key varchar(255) not null primary key,
+	tokens     double not null,
+	updated    datetime(6) not null,
+	index idx_rate_limits_updated (updated)
+);
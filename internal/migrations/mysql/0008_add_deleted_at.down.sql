@@ -0,0 +1,7 @@
+alter table users
+	drop index idx_users_deleted_at,
+	drop column deleted_at;
+
+alter table posts
+	drop index idx_posts_deleted_at,
+	drop column deleted_at;
@@ -0,0 +1,3 @@
+drop table users;
+
+drop collation ci;
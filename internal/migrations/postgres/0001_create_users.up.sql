@@ -0,0 +1,15 @@
+-- Compares emails case-insensitively, like MySQL's default collation does
+create collation ci (provider = icu, locale = 'und-u-ks-level2', deterministic = false);
+
+create table users (
+	id              int generated by default as identity primary key,
+	name            varchar(255) not null,
+	email           varchar(255) collate ci not null,
+	-- NULL for accounts that only sign in through an identity provider
+	hashed_password varchar(255) null,
+	created_at      timestamp not null,
+	deletion_due    timestamp null,
+	constraint uq_users_email unique (email)
+);
+
+create index idx_users_deletion_due on users (deletion_due);
@@ -0,0 +1,13 @@
+create table posts (
+	id      int generated by default as identity primary key,
+	title   varchar(100) not null,
+	content text not null,
+	created timestamp not null,
+	expires timestamp not null,
+	-- NULL for anonymous and anonymised posts
+	user_id int null
+);
+
+create index idx_posts_created on posts (created);
+
+create index idx_posts_user_id on posts (user_id);
@@ -0,0 +1,8 @@
+-- Session data, laid out like github.com/alexedwards/scs/postgresstore does
+create table sessions (
+	token  text primary key,
+	data   bytea not null,
+	expiry timestamptz not null
+);
+
+create index sessions_expiry_idx on sessions (expiry);
@@ -0,0 +1,11 @@
+create table user_sessions (
+	id         int generated by default as identity primary key,
+	user_id    int not null,
+	token      char(43) not null unique,
+	created    timestamp not null,
+	last_seen  timestamp not null,
+	ip         varchar(45) not null,
+	user_agent varchar(255) not null
+);
+
+create index idx_user_sessions_user_id on user_sessions (user_id);
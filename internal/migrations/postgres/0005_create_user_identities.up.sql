@@ -0,0 +1,11 @@
+create table user_identities (
+	id       int generated by default as identity primary key,
+	user_id  int not null,
+	provider varchar(64) not null,
+	subject  varchar(255) not null,
+	email    varchar(255) not null,
+	created  timestamp not null,
+	constraint uq_user_identities_subject unique (provider, subject)
+);
+
+create index idx_user_identities_user_id on user_identities (user_id);
@@ -0,0 +1,15 @@
+create table login_attempts (
+	attempt_key  varchar(320) not null primary key,
+	failures     int not null,
+	last_failure timestamp not null
+);
+
+create table login_events (
+	id      bigint generated by default as identity primary key,
+	kind    varchar(16) not null,
+	email   varchar(255) not null,
+	ip      varchar(45) not null,
+	created timestamp not null
+);
+
+create index idx_login_events_email on login_events (email);
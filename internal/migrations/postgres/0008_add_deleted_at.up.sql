@@ -0,0 +1,9 @@
+-- Posts and users go to the trash first and are purged once the retention
+-- window is over, NULL for everything that isn't in the trash
+alter table posts add column deleted_at timestamp null;
+
+create index idx_posts_deleted_at on posts (deleted_at);
+
+alter table users add column deleted_at timestamp null;
+
+create index idx_users_deleted_at on users (deleted_at);
@@ -0,0 +1,12 @@
+create table users (
+	id              integer primary key autoincrement,
+	name            varchar(255) not null,
+	email           varchar(255) not null collate nocase,
+	-- NULL for accounts that only sign in through an identity provider
+	hashed_password varchar(255) null,
+	created_at      datetime not null,
+	deletion_due    datetime null,
+	constraint uq_users_email unique (email)
+);
+
+create index idx_users_deletion_due on users (deletion_due);
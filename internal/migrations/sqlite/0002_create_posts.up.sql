@@ -0,0 +1,13 @@
+create table posts (
+	id      integer primary key autoincrement,
+	title   varchar(100) not null,
+	content text not null,
+	created datetime not null,
+	expires datetime not null,
+	-- NULL for anonymous and anonymised posts
+	user_id integer null
+);
+
+create index idx_posts_created on posts (created);
+
+create index idx_posts_user_id on posts (user_id);
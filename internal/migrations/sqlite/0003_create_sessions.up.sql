@@ -0,0 +1,8 @@
+-- Session data kept by internal/sessionstore
+create table sessions (
+	token  text primary key,
+	data   blob not null,
+	expiry datetime not null
+);
+
+create index sessions_expiry_idx on sessions (expiry);
@@ -0,0 +1,11 @@
+create table user_sessions (
+	id         integer primary key autoincrement,
+	user_id    integer not null,
+	token      char(43) not null unique,
+	created    datetime not null,
+	last_seen  datetime not null,
+	ip         varchar(45) not null,
+	user_agent varchar(255) not null
+);
+
+create index idx_user_sessions_user_id on user_sessions (user_id);
@@ -0,0 +1,15 @@
+create table login_attempts (
+	attempt_key  varchar(320) not null primary key,
+	failures     integer not null,
+	last_failure datetime not null
+);
+
+create table login_events (
+	id      integer primary key autoincrement,
+	kind    varchar(16) not null,
+	email   varchar(255) not null,
+	ip      varchar(45) not null,
+	created datetime not null
+);
+
+create index idx_login_events_email on login_events (email);
@@ -0,0 +1,7 @@
+create table rate_limits (
+	bucket_key varchar(255) not null primary key,
+	tokens     real not null,
+	updated    datetime not null
+);
+
+create index idx_rate_limits_updated on rate_limits (updated);
@@ -0,0 +1,7 @@
+drop index idx_users_deleted_at;
+
+alter table users drop column deleted_at;
+
+drop index idx_posts_deleted_at;
+
+alter table posts drop column deleted_at;
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// column maps a column to the field of T it is scanned into.
+type column[T any] struct {
+	name  string
+	field func(v *T) any
+}
+
+// columns is what a model selects for a T, in order. Queries name the columns
+// rather than select *, so adding a column to a table doesn't break reads, and
+// the same list drives the select and the scan so the two can't disagree.
+type columns[T any] []column[T]
+
+// list returns the column names for a select list.
+func (c columns[T]) list() string {
+	return strings.Join(c.names(), ", ")
+}
+
+func (c columns[T]) names() []string {
+	names := make([]string, len(c))
+	for i, col := range c {
+		names[i] = col.name
+	}
+	return names
+}
+
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scan reads the current row of s into a new T.
+func (c columns[T]) scan(s scanner) (*T, error) {
+	v := new(T)
+	dest := make([]any, len(c))
+	for i, col := range c {
+		dest[i] = col.field(v)
+	}
+	if err := s.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// all scans every row of rows and closes it.
+func (c columns[T]) all(rows *sql.Rows) ([]*T, error) {
+	defer rows.Close()
+
+	var list []*T
+	for rows.Next() {
+		v, err := c.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// nullString scans a nullable column into a string, NULL becoming "".
+type nullString struct{ s *string }
+
+func (n nullString) Scan(src any) error {
+	var ns sql.NullString
+	if err := ns.Scan(src); err != nil {
+		return err
+	}
+	*n.s = ns.String
+	return nil
+}
+
+// nullTime scans a nullable column into a *time.Time, NULL becoming nil.
+type nullTime struct{ t **time.Time }
+
+func (n nullTime) Scan(src any) error {
+	var nt sql.NullTime
+	if err := nt.Scan(src); err != nil {
+		return err
+	}
+	*n.t = nil
+	if nt.Valid {
+		*n.t = &nt.Time
+	}
+	return nil
+}
@@ -4,4 +4,5 @@ import "errors"
 
 var ErrNoRecord = errors.New("models: no matching record found")
 var ErrInvalidCredentials = errors.New("models: invalid credentials")
-var ErrDuplicateEmail = errors.New("models: duplicate email")
\ No newline at end of file
+var ErrDuplicateEmail = errors.New("models: duplicate email")
+var ErrDuplicateIdentity = errors.New("models: identity already linked")
\ No newline at end of file
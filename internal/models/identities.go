@@ -0,0 +1,86 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"example.com/practice-rest/internal/sqldb"
+	"time"
+)
+
+// IdentityModel links accounts at external identity providers to users in the
+// user_identities table, see
+// internal/migrations/mysql/0005_create_user_identities.up.sql.
+type IdentityModel struct {
+	DB           sqldb.Querier
+	Dialect      sqldb.Dialect
+	QueryTimeout time.Duration
+}
+
+// identityRow is a row of user_identities, only the models need the whole of
+// it.
+type identityRow struct {
+	ID       int
+	UserID   int
+	Provider string
+	Subject  string
+	Email    string
+	Created  time.Time
+}
+
+// identityColumns are the columns of user_identities an identityRow is read
+// from.
+var identityColumns = columns[identityRow]{
+	{"id", func(i *identityRow) any { return &i.ID }},
+	{"user_id", func(i *identityRow) any { return &i.UserID }},
+	{"provider", func(i *identityRow) any { return &i.Provider }},
+	{"subject", func(i *identityRow) any { return &i.Subject }},
+	{"email", func(i *identityRow) any { return &i.Email }},
+	{"created", func(i *identityRow) any { return &i.Created }},
+}
+
+// UserID returns the user linked to subject at provider. Deleted users are
+// found too, signing in restores their account like logging in does.
+func (identity *IdentityModel) UserID(ctx context.Context, provider, subject string) (_ int, err error) {
+	query := `select ` + identityColumns.list() + ` from user_identities where provider = ? and subject = ?`
+	ctx, span := startSpan(ctx, identity.Dialect, "IdentityModel.UserID", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, identity.QueryTimeout)
+	defer cancel()
+
+	row, err := identityColumns.scan(identity.DB.QueryRowContext(ctx, identity.Dialect.Rebind(query), provider, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	return row.UserID, nil
+}
+
+func (identity *IdentityModel) Insert(ctx context.Context, userID int, provider, subject, email string) (err error) {
+	query := `insert into user_identities (user_id, provider, subject, email, created)
+			  values (?, ?, ?, ?, ?)`
+	ctx, span := startSpan(ctx, identity.Dialect, "IdentityModel.Insert", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, identity.QueryTimeout)
+	defer cancel()
+
+	_, err = identity.DB.ExecContext(ctx, identity.Dialect.Rebind(query), userID, provider, subject, email, clock(nil))
+	if identity.Dialect.IsUniqueViolation(err) {
+		return ErrDuplicateIdentity
+	}
+	return err
+}
+
+func (identity *IdentityModel) DeleteByUser(ctx context.Context, userID int) (err error) {
+	query := `delete from user_identities where user_id = ?`
+	ctx, span := startSpan(ctx, identity.Dialect, "IdentityModel.DeleteByUser", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, identity.QueryTimeout)
+	defer cancel()
+
+	_, err = identity.DB.ExecContext(ctx, identity.Dialect.Rebind(query), userID)
+	return err
+}
@@ -0,0 +1,716 @@
+package models
+
+import (
+	"context"
+	"example.com/practice-rest/internal/passwords"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The memory repositories keep everything in process, for running the server
+// and handler tests without a database. They behave like their MySQL
+// counterparts, including the second precision of datetime columns, and hand
+// out copies so callers can't change stored records.
+
+type memoryPost struct {
+	Post
+	userID int
+}
+
+// copy returns the post without sharing DeletedAt with the stored one.
+func (p *memoryPost) copy() Post {
+	post := p.Post
+	if p.DeletedAt != nil {
+		deletedAt := *p.DeletedAt
+		post.DeletedAt = &deletedAt
+	}
+	return post
+}
+
+type MemoryPostRepository struct {
+	// Now replaces time.Now in tests.
+	Now func() time.Time
+
+	mu     sync.RWMutex
+	posts  []*memoryPost
+	nextID int
+}
+
+func NewMemoryPostRepository() *MemoryPostRepository {
+	return &MemoryPostRepository{}
+}
+
+func (m *MemoryPostRepository) Insert(ctx context.Context, userID int, title string, content string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := clock(m.Now)
+	m.nextID++
+	m.posts = append(m.posts, &memoryPost{
+		Post:   Post{ID: m.nextID, Title: title, Content: content, Created: now, Expires: now.AddDate(0, 0, 30)},
+		userID: userID,
+	})
+	m.keep(ctx, m.nextID, nil)
+	return m.nextID, nil
+}
+
+func (m *MemoryPostRepository) Get(ctx context.Context, id int) (*Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := clock(m.Now)
+	for _, p := range m.posts {
+		if p.ID == id && p.Expires.After(now) && p.DeletedAt == nil {
+			post := p.copy()
+			return &post, nil
+		}
+	}
+	return nil, ErrNoRecord
+}
+
+func (m *MemoryPostRepository) Latest(ctx context.Context) ([]*Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := clock(m.Now)
+	var posts []*Post
+	for _, p := range m.posts {
+		if p.Expires.After(now) && p.DeletedAt == nil {
+			post := p.copy()
+			posts = append(posts, &post)
+		}
+	}
+
+	// Later inserts have higher ids, which breaks ties within a second
+	sort.Slice(posts, func(i, j int) bool {
+		if !posts[i].Created.Equal(posts[j].Created) {
+			return posts[i].Created.After(posts[j].Created)
+		}
+		return posts[i].ID > posts[j].ID
+	})
+	if len(posts) > 10 {
+		posts = posts[:10]
+	}
+	return posts, nil
+}
+
+func (m *MemoryPostRepository) ByUser(ctx context.Context, userID int) ([]*Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var posts []*Post
+	for _, p := range m.posts {
+		if userID != 0 && p.userID == userID && p.DeletedAt == nil {
+			post := p.copy()
+			posts = append(posts, &post)
+		}
+	}
+	return posts, nil
+}
+
+func (m *MemoryPostRepository) AnonymiseByUser(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.posts {
+		if p.userID == userID {
+			m.keep(ctx, p.ID, p)
+			p.userID = 0
+		}
+	}
+	return nil
+}
+
+func (m *MemoryPostRepository) DeleteByUser(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.posts[:0]
+	for _, p := range m.posts {
+		if userID == 0 || p.userID != userID {
+			kept = append(kept, p)
+		} else {
+			m.keep(ctx, p.ID, p)
+		}
+	}
+	m.posts = kept
+	return nil
+}
+
+func (m *MemoryPostRepository) Trash(ctx context.Context, id, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.posts {
+		if p.ID == id && userID != 0 && p.userID == userID && p.DeletedAt == nil {
+			m.keep(ctx, p.ID, p)
+			now := clock(m.Now)
+			p.DeletedAt = &now
+			return nil
+		}
+	}
+	return ErrNoRecord
+}
+
+func (m *MemoryPostRepository) Restore(ctx context.Context, id, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.posts {
+		if p.ID == id && userID != 0 && p.userID == userID && p.DeletedAt != nil {
+			m.keep(ctx, p.ID, p)
+			p.DeletedAt = nil
+			return nil
+		}
+	}
+	return ErrNoRecord
+}
+
+func (m *MemoryPostRepository) Trashed(ctx context.Context, userID int) ([]*Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var posts []*Post
+	for _, p := range m.posts {
+		if userID != 0 && p.userID == userID && p.DeletedAt != nil {
+			post := p.copy()
+			posts = append(posts, &post)
+		}
+	}
+
+	sort.Slice(posts, func(i, j int) bool {
+		if !posts[i].DeletedAt.Equal(*posts[j].DeletedAt) {
+			return posts[i].DeletedAt.After(*posts[j].DeletedAt)
+		}
+		return posts[i].ID > posts[j].ID
+	})
+	return posts, nil
+}
+
+func (m *MemoryPostRepository) PurgeTrashed(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.posts[:0]
+	for _, p := range m.posts {
+		if p.DeletedAt == nil || p.DeletedAt.After(before) {
+			kept = append(kept, p)
+		} else {
+			m.keep(ctx, p.ID, p)
+		}
+	}
+	purged := len(m.posts) - len(kept)
+	m.posts = kept
+	return purged, nil
+}
+
+// keep journals post id as it is now, p or missing if p is nil, so a failed
+// unit of work ctx belongs to can put it back. The caller must hold the lock.
+func (m *MemoryPostRepository) keep(ctx context.Context, id int, p *memoryPost) {
+	var saved *memoryPost
+	if p != nil {
+		saved = &memoryPost{Post: p.copy(), userID: p.userID}
+	}
+
+	journal(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// Posts are kept in id order
+		i, found := slices.BinarySearchFunc(m.posts, id, func(p *memoryPost, id int) int { return p.ID - id })
+		switch {
+		case saved == nil && found:
+			m.posts = slices.Delete(m.posts, i, i+1)
+		case found:
+			m.posts[i] = saved
+		case saved != nil:
+			m.posts = slices.Insert(m.posts, i, saved)
+		}
+	})
+}
+
+type MemoryUserRepository struct {
+	Hasher *passwords.Hasher
+	// Now replaces time.Now in tests.
+	Now func() time.Time
+
+	mu     sync.RWMutex
+	users  map[int]*User
+	nextID int
+}
+
+func NewMemoryUserRepository(hasher *passwords.Hasher) *MemoryUserRepository {
+	return &MemoryUserRepository{Hasher: hasher, users: map[int]*User{}}
+}
+
+// byEmail finds a user the way the case-insensitive collation of users.email
+// does, including deleted ones. The caller must hold the lock.
+func (m *MemoryUserRepository) byEmail(email string) *User {
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			return u
+		}
+	}
+	return nil
+}
+
+// active returns user id unless it doesn't exist or is deleted. The caller
+// must hold the lock.
+func (m *MemoryUserRepository) active(id int) *User {
+	if u, ok := m.users[id]; ok && u.DeletedAt == nil {
+		return u
+	}
+	return nil
+}
+
+func (m *MemoryUserRepository) Insert(ctx context.Context, name, email, hashedPassword string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.byEmail(email) != nil {
+		return 0, ErrDuplicateEmail
+	}
+
+	m.nextID++
+	m.users[m.nextID] = &User{
+		ID:             m.nextID,
+		Name:           name,
+		Email:          email,
+		HashedPassword: hashedPassword,
+		CreatedAt:      clock(m.Now),
+	}
+	m.keep(ctx, m.nextID, nil)
+	return m.nextID, nil
+}
+
+func (m *MemoryUserRepository) Authenticate(ctx context.Context, email, password string) (int, error) {
+	m.mu.RLock()
+	u := m.byEmail(email)
+	var id int
+	var hashedPassword string
+	if u != nil {
+		id, hashedPassword = u.ID, u.HashedPassword
+	}
+	m.mu.RUnlock()
+
+	// Accounts created through an identity provider have no password
+	if hashedPassword == "" {
+		return 0, ErrInvalidCredentials
+	}
+
+	// Hashing is slow, so the lock isn't held while verifying
+	match, rehash, err := m.Hasher.Verify(password, hashedPassword)
+	if err != nil {
+		return 0, err
+	}
+	if !match {
+		return 0, ErrInvalidCredentials
+	}
+
+	if rehash {
+		newHash, err := m.Hasher.Hash(password)
+		if err != nil {
+			return 0, err
+		}
+
+		// Same as the MySQL update, a concurrent password change wins
+		m.mu.Lock()
+		if u, ok := m.users[id]; ok && u.HashedPassword == hashedPassword {
+			m.keep(ctx, id, u)
+			u.HashedPassword = newHash
+		}
+		m.mu.Unlock()
+	}
+
+	return id, nil
+}
+
+func (m *MemoryUserRepository) IDByEmail(ctx context.Context, email string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if u := m.byEmail(email); u != nil {
+		return u.ID, nil
+	}
+	return 0, ErrNoRecord
+}
+
+func (m *MemoryUserRepository) Get(ctx context.Context, id int) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u := m.active(id)
+	if u == nil {
+		return nil, ErrNoRecord
+	}
+	return copyUser(u), nil
+}
+
+func (m *MemoryUserRepository) ScheduleDeletion(ctx context.Context, id int, due time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u := m.active(id); u != nil {
+		m.keep(ctx, id, u)
+		now := clock(m.Now)
+		due = due.UTC().Truncate(time.Second)
+		u.DeletedAt, u.DeletionDue = &now, &due
+	}
+	return nil
+}
+
+func (m *MemoryUserRepository) CancelDeletion(ctx context.Context, id int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || u.DeletionDue == nil {
+		return false, nil
+	}
+	m.keep(ctx, id, u)
+	u.DeletedAt, u.DeletionDue = nil, nil
+	return true, nil
+}
+
+func (m *MemoryUserRepository) DueForDeletion(ctx context.Context) ([]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := clock(m.Now)
+	var ids []int
+	for _, u := range m.users {
+		if u.DeletionDue != nil && !u.DeletionDue.After(now) {
+			ids = append(ids, u.ID)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (m *MemoryUserRepository) Delete(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok {
+		m.keep(ctx, id, u)
+		delete(m.users, id)
+	}
+	return nil
+}
+
+func (m *MemoryUserRepository) Exist(ctx context.Context, id int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.active(id) != nil, nil
+}
+
+// copyUser returns u without sharing its time pointers.
+func copyUser(u *User) *User {
+	user := *u
+	if u.DeletionDue != nil {
+		due := *u.DeletionDue
+		user.DeletionDue = &due
+	}
+	if u.DeletedAt != nil {
+		deletedAt := *u.DeletedAt
+		user.DeletedAt = &deletedAt
+	}
+	return &user
+}
+
+// keep journals user id as it is now, u or missing if u is nil, so a failed
+// unit of work ctx belongs to can put it back. The caller must hold the lock.
+func (m *MemoryUserRepository) keep(ctx context.Context, id int, u *User) {
+	var saved *User
+	if u != nil {
+		saved = copyUser(u)
+	}
+
+	journal(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if saved == nil {
+			delete(m.users, id)
+		} else {
+			m.users[id] = saved
+		}
+	})
+}
+
+type MemorySessionRepository struct {
+	// Now replaces time.Now in tests.
+	Now func() time.Time
+
+	mu       sync.RWMutex
+	sessions map[int]*Session
+	nextID   int
+}
+
+func NewMemorySessionRepository() *MemorySessionRepository {
+	return &MemorySessionRepository{sessions: map[int]*Session{}}
+}
+
+func (m *MemorySessionRepository) Insert(ctx context.Context, userID int, token, ip, userAgent string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	now := clock(m.Now)
+	m.nextID++
+	m.sessions[m.nextID] = &Session{
+		ID:        m.nextID,
+		UserID:    userID,
+		Token:     token,
+		Created:   now,
+		LastSeen:  now,
+		IP:        ip,
+		UserAgent: userAgent,
+	}
+	m.keep(ctx, m.nextID, nil)
+	return m.nextID, nil
+}
+
+func (m *MemorySessionRepository) Touch(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := clock(m.Now)
+	for _, s := range m.sessions {
+		if s.Token == token && s.LastSeen.Before(now.Add(-time.Minute)) {
+			m.keep(ctx, s.ID, s)
+			s.LastSeen = now
+		}
+	}
+	return nil
+}
+
+func (m *MemorySessionRepository) Get(ctx context.Context, id, userID int) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[id]
+	if !ok || s.UserID != userID {
+		return nil, ErrNoRecord
+	}
+	session := *s
+	return &session, nil
+}
+
+func (m *MemorySessionRepository) ListByUser(ctx context.Context, userID int) ([]*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var sessions []*Session
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			session := *s
+			sessions = append(sessions, &session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeen.Equal(sessions[j].LastSeen) {
+			return sessions[i].LastSeen.After(sessions[j].LastSeen)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (m *MemorySessionRepository) Delete(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok {
+		m.keep(ctx, id, s)
+		delete(m.sessions, id)
+	}
+	return nil
+}
+
+func (m *MemorySessionRepository) DeleteByToken(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.Token == token {
+			m.keep(ctx, id, s)
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemorySessionRepository) DeleteByUser(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.UserID == userID {
+			m.keep(ctx, id, s)
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// keep journals session id as it is now, s or missing if s is nil, so a failed
+// unit of work ctx belongs to can put it back. The caller must hold the lock.
+func (m *MemorySessionRepository) keep(ctx context.Context, id int, s *Session) {
+	var saved *Session
+	if s != nil {
+		session := *s
+		saved = &session
+	}
+
+	journal(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if saved == nil {
+			delete(m.sessions, id)
+		} else {
+			m.sessions[id] = saved
+		}
+	})
+}
+
+type MemoryIdentityRepository struct {
+	mu         sync.RWMutex
+	identities map[[2]string]int
+}
+
+func NewMemoryIdentityRepository() *MemoryIdentityRepository {
+	return &MemoryIdentityRepository{identities: map[[2]string]int{}}
+}
+
+func (m *MemoryIdentityRepository) UserID(ctx context.Context, provider, subject string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id, ok := m.identities[[2]string{provider, subject}]
+	if !ok {
+		return 0, ErrNoRecord
+	}
+	return id, nil
+}
+
+func (m *MemoryIdentityRepository) Insert(ctx context.Context, userID int, provider, subject, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{provider, subject}
+	if _, ok := m.identities[key]; ok {
+		return ErrDuplicateIdentity
+	}
+	m.keep(ctx, key)
+	m.identities[key] = userID
+	return nil
+}
+
+func (m *MemoryIdentityRepository) DeleteByUser(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, id := range m.identities {
+		if id == userID {
+			m.keep(ctx, key)
+			delete(m.identities, key)
+		}
+	}
+	return nil
+}
+
+var (
+	_ PostRepository     = (*MemoryPostRepository)(nil)
+	_ UserRepository     = (*MemoryUserRepository)(nil)
+	_ SessionRepository  = (*MemorySessionRepository)(nil)
+	_ IdentityRepository = (*MemoryIdentityRepository)(nil)
+)
+
+// keep journals identity key as it is now, so a failed unit of work ctx
+// belongs to can put it back. The caller must hold the lock.
+func (m *MemoryIdentityRepository) keep(ctx context.Context, key [2]string) {
+	userID, existed := m.identities[key]
+
+	journal(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if existed {
+			m.identities[key] = userID
+		} else {
+			delete(m.identities, key)
+		}
+	})
+}
+
+// memoryJournal is how to undo the changes a unit of work made to the memory
+// repositories, oldest first.
+type memoryJournal struct {
+	mu   sync.Mutex
+	undo []func()
+}
+
+type memoryJournalKey struct{}
+
+// journal records how to undo a change made by the unit of work ctx belongs
+// to. Changes made outside a unit of work aren't journalled. undo takes the
+// repository's lock, so it only runs once the unit of work is over.
+func journal(ctx context.Context, undo func()) {
+	if j, ok := ctx.Value(memoryJournalKey{}).(*memoryJournal); ok {
+		j.mu.Lock()
+		j.undo = append(j.undo, undo)
+		j.mu.Unlock()
+	}
+}
+
+// MemoryUnitOfWork runs units of work one at a time with repos. The
+// repositories journal the records a unit of work changes, and only those are
+// put back if it fails, so changes made outside it in the meantime are kept.
+// Like auto increment columns, ids handed out by a failed unit of work aren't
+// reused.
+type MemoryUnitOfWork struct {
+	repos Repositories
+	mu    sync.Mutex
+}
+
+func NewMemoryUnitOfWork(repos Repositories) *MemoryUnitOfWork {
+	return &MemoryUnitOfWork{repos: repos}
+}
+
+type memoryUnitKey struct{}
+
+func (m *MemoryUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context, repos Repositories) error) error {
+	// A nested unit of work already holds the lock
+	if ctx.Value(memoryUnitKey{}) != m {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		ctx = context.WithValue(ctx, memoryUnitKey{}, m)
+	}
+
+	// A nested unit of work has a journal of its own, which is handed to the
+	// enclosing one when it succeeds so a later failure there undoes it too
+	j := &memoryJournal{}
+	err := fn(context.WithValue(ctx, memoryJournalKey{}, j), m.repos)
+	if err != nil {
+		for i := len(j.undo) - 1; i >= 0; i-- {
+			j.undo[i]()
+		}
+		return err
+	}
+
+	if parent, ok := ctx.Value(memoryJournalKey{}).(*memoryJournal); ok {
+		parent.mu.Lock()
+		parent.undo = append(parent.undo, j.undo...)
+		parent.mu.Unlock()
+	}
+	return nil
+}
@@ -1,21 +1,43 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"example.com/practice-rest/internal/sqldb"
 	"time"
 )
 
+// Post is a row of the posts table. Posts written while logged in record their
+// author in posts.user_id, which is NULL for anonymous and anonymised posts.
+// DeletedAt is set while the post is in its author's trash.
 type Post struct {
-	ID      int       `json:"id"`
-	Title   string    `json:"title"`
-	Content string    `json:"content"`
-	Created time.Time `json:"created"`
-	Expires time.Time `json:"expires"`
+	ID        int        `json:"id"`
+	Title     string     `json:"title"`
+	Content   string     `json:"content"`
+	Created   time.Time  `json:"created"`
+	Expires   time.Time  `json:"expires"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// postColumns are the columns of posts a Post is read from.
+var postColumns = columns[Post]{
+	{"id", func(p *Post) any { return &p.ID }},
+	{"title", func(p *Post) any { return &p.Title }},
+	{"content", func(p *Post) any { return &p.Content }},
+	{"created", func(p *Post) any { return &p.Created }},
+	{"expires", func(p *Post) any { return &p.Expires }},
+	{"deleted_at", func(p *Post) any { return nullTime{&p.DeletedAt} }},
 }
 
 type PostModel struct {
-	DB *sql.DB
+	DB           sqldb.Querier
+	Dialect      sqldb.Dialect
+	QueryTimeout time.Duration
+	// Statements, if set, keeps Get and Latest prepared
+	Statements *sqldb.StatementCache
+	// Replicas, if set, serve Get, Latest, ByUser and Trashed
+	Replicas *sqldb.Replicas
 }
 
 /*
@@ -25,35 +47,38 @@ type PostModel struct {
 * DB.Exec() -is used for INSERT, UPDATE and DELETE queries, and it does not return any rows.
  */
 
-func (post *PostModel) Insert(title string, content string) (int, error) {
-	query := `insert into posts (title, content, created, expires) 
- 			  values (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 30 DAY))`
+// Insert creates a post written by userID, or an anonymous one if userID is zero.
+func (post *PostModel) Insert(ctx context.Context, userID int, title string, content string) (_ int, err error) {
+	query := `insert into posts (title, content, created, expires, user_id) 
+ 			  values (?, ?, ?, ?, ?)`
+	ctx, span := startSpan(ctx, post.Dialect, "PostModel.Insert", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, post.QueryTimeout)
+	defer cancel()
 
-	result, err := post.DB.Exec(query, title, content)
-	if err != nil {
-		return 0, err
-	}
+	author := sql.NullInt64{Int64: int64(userID), Valid: userID != 0}
+	now := clock(nil)
 
-	id, err := result.LastInsertId()
+	id, err := post.Dialect.InsertID(ctx, post.DB, query, title, content, now, now.AddDate(0, 0, 30), author)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 
 	return int(id), nil
 }
 
-func (post *PostModel) Get(id int) (*Post, error) {
-	query := `select * from posts where expires > UTC_TIMESTAMP() and id = ?`
-	row := post.DB.QueryRow(query, id)
+func (post *PostModel) Get(ctx context.Context, id int) (_ *Post, err error) {
+	query := `select ` + postColumns.list() + ` from posts where expires > ? and id = ? and deleted_at is null`
+	ctx, span := startSpan(ctx, post.Dialect, "PostModel.Get", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, post.QueryTimeout)
+	defer cancel()
 
-	p := &Post{}
+	row := reader(ctx, post.Replicas, post.DB, post.Statements).QueryRowContext(ctx, post.Dialect.Rebind(query), clock(nil), id)
 
-	// Use row.Scan() to copy the values from each field in sql.Row to the
-	// corresponding field in the Snippet struct. Notice that the arguments
-	// to row.Scan are *pointers* to the place you want to copy the data into,
-	// and the number of arguments must be exactly the same as the number of
-	// columns returned by your statement.
-	err := row.Scan(&p.ID, &p.Title, &p.Content, &p.Created, &p.Expires)
+	// postColumns.scan() copies the values from each column in the row to the
+	// matching field of a new Post, in the order they are selected in.
+	p, err := postColumns.scan(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -64,54 +89,121 @@ func (post *PostModel) Get(id int) (*Post, error) {
 	return p, nil
 }
 
-func (post *PostModel) Latest() ([]*Post, error) {
-	query := `select * from posts where expires > UTC_TIMESTAMP() order by created desc limit 10`
-	rows, err := post.DB.Query(query)
+func (post *PostModel) Latest(ctx context.Context) (_ []*Post, err error) {
+	query := `select ` + postColumns.list() + ` from posts where expires > ? and deleted_at is null order by created desc limit 10`
+	ctx, span := startSpan(ctx, post.Dialect, "PostModel.Latest", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, post.QueryTimeout)
+	defer cancel()
+
+	rows, err := reader(ctx, post.Replicas, post.DB, post.Statements).QueryContext(ctx, post.Dialect.Rebind(query), clock(nil))
 
 	if err != nil {
 		return nil, err
 	}
 
-	// We defer rows.Close() to ensure the sql.Rows resultset is
-	// always properly closed before the Latest() method returns. This defers
-	// statement should come *after* you check for an error from the Query()
-	// method. Otherwise, if Query() returns an error, you'll get a panic
-	// trying to close a nil resultset.
-	defer rows.Close()
-
-	// Initialize empty slice to hold the posts
-	var posts []*Post
-
-	// Use rows.Next to iterate through the rows in the resultset. This
-	// prepares the first (and then each subsequent) row to be acted on by the
-	// rows.Scan() method. If iteration over all the rows completes then the
-	// resultset automatically closes itself and frees-up the underlying
-	// database connection.
-
-	for rows.Next() {
-		// Create a pointer to a new zeroed Post struct.
-		p := &Post{}
-		// Use rows.Scan() to copy the values from each field in the row to the
-		// new Post object that we created. Again, the arguments to row.Scan()
-		// must be pointers to the place you want to copy the data into, and the
-		// number of arguments must be exactly the same as the number of
-		// columns returned by your statement.
-		err = rows.Scan(&p.ID, &p.Title, &p.Content, &p.Created, &p.Expires)
-		if err != nil {
-			return nil, err
-		}
-		// Append it to the slice of posts.
-		posts = append(posts, p)
+	// postColumns.all() scans every row into a new Post, closes the resultset
+	// and also returns any error that was encountered during the iteration,
+	// so an incomplete list is never mistaken for the whole resultset.
+	return postColumns.all(rows)
+}
+
+// ByUser returns every post written by userID, including expired ones but not
+// the ones in the trash.
+func (post *PostModel) ByUser(ctx context.Context, userID int) (_ []*Post, err error) {
+	query := `select ` + postColumns.list() + ` from posts where user_id = ? and deleted_at is null order by created`
+	ctx, span := startSpan(ctx, post.Dialect, "PostModel.ByUser", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, post.QueryTimeout)
+	defer cancel()
+
+	rows, err := reader(ctx, post.Replicas, post.DB, nil).QueryContext(ctx, post.Dialect.Rebind(query), userID)
+	if err != nil {
+		return nil, err
 	}
 
-	// When the rows.Next() loop has finished we call rows.Err() to retrieve any
-	// error that was encountered during the iteration. It's important to
-	// call this - don't assume that a successful iteration was completed
-	// over the whole resultset.
-	if err = rows.Err(); err != nil {
+	return postColumns.all(rows)
+}
+
+// AnonymiseByUser detaches every post written by userID from its author.
+func (post *PostModel) AnonymiseByUser(ctx context.Context, userID int) (err error) {
+	query := `update posts set user_id = null where user_id = ?`
+	ctx, span := startSpan(ctx, post.Dialect, "PostModel.AnonymiseByUser", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, post.QueryTimeout)
+	defer cancel()
+
+	_, err = post.DB.ExecContext(ctx, post.Dialect.Rebind(query), userID)
+	return err
+}
+
+// Trash moves post id to the trash, but only if userID wrote it.
+func (post *PostModel) Trash(ctx context.Context, id, userID int) (err error) {
+	query := `update posts set deleted_at = ? where id = ? and user_id = ? and deleted_at is null`
+	ctx, span := startSpan(ctx, post.Dialect, "PostModel.Trash", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, post.QueryTimeout)
+	defer cancel()
+
+	result, err := post.DB.ExecContext(ctx, post.Dialect.Rebind(query), clock(nil), id, userID)
+	return affectedOne(result, err)
+}
+
+// Restore takes post id out of the trash, but only if userID wrote it.
+func (post *PostModel) Restore(ctx context.Context, id, userID int) (err error) {
+	query := `update posts set deleted_at = null where id = ? and user_id = ? and deleted_at is not null`
+	ctx, span := startSpan(ctx, post.Dialect, "PostModel.Restore", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, post.QueryTimeout)
+	defer cancel()
+
+	result, err := post.DB.ExecContext(ctx, post.Dialect.Rebind(query), id, userID)
+	return affectedOne(result, err)
+}
+
+// Trashed returns the posts of userID that are in the trash, most recently
+// deleted first.
+func (post *PostModel) Trashed(ctx context.Context, userID int) (_ []*Post, err error) {
+	query := `select ` + postColumns.list() + ` from posts where user_id = ? and deleted_at is not null
+			  order by deleted_at desc, id desc`
+	ctx, span := startSpan(ctx, post.Dialect, "PostModel.Trashed", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, post.QueryTimeout)
+	defer cancel()
+
+	rows, err := reader(ctx, post.Replicas, post.DB, nil).QueryContext(ctx, post.Dialect.Rebind(query), userID)
+	if err != nil {
 		return nil, err
 	}
 
-	// If everything went OK then return the Posts slice.
-	return posts, nil
+	return postColumns.all(rows)
+}
+
+// PurgeTrashed hard deletes the posts that went to the trash at or before
+// before, returning how many there were.
+func (post *PostModel) PurgeTrashed(ctx context.Context, before time.Time) (_ int, err error) {
+	query := `delete from posts where deleted_at <= ?`
+	ctx, span := startSpan(ctx, post.Dialect, "PostModel.PurgeTrashed", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, post.QueryTimeout)
+	defer cancel()
+
+	result, err := post.DB.ExecContext(ctx, post.Dialect.Rebind(query), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (post *PostModel) DeleteByUser(ctx context.Context, userID int) (err error) {
+	query := `delete from posts where user_id = ?`
+	ctx, span := startSpan(ctx, post.Dialect, "PostModel.DeleteByUser", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, post.QueryTimeout)
+	defer cancel()
+
+	_, err = post.DB.ExecContext(ctx, post.Dialect.Rebind(query), userID)
+	return err
 }
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"example.com/practice-rest/internal/sqldb"
+	"time"
+)
+
+// The repositories are what the handlers depend on, so the storage behind them
+// can be swapped. Every implementation returns ErrNoRecord for missing rows,
+// hides expired posts outside of ByUser, hides posts in the trash outside of
+// Trashed, hides deleted users outside of the lookups that sign them in again
+// and rejects a second user with the same email (compared case-insensitively,
+// deleted users included) with ErrDuplicateEmail.
+
+type PostRepository interface {
+	// Insert creates a post written by userID, or an anonymous one if userID
+	// is zero. Posts expire after 30 days.
+	Insert(ctx context.Context, userID int, title string, content string) (int, error)
+	Get(ctx context.Context, id int) (*Post, error)
+	// Latest returns the 10 newest posts that haven't expired.
+	Latest(ctx context.Context) ([]*Post, error)
+	// ByUser returns every post written by userID, including expired ones.
+	ByUser(ctx context.Context, userID int) ([]*Post, error)
+	AnonymiseByUser(ctx context.Context, userID int) error
+	DeleteByUser(ctx context.Context, userID int) error
+	// Trash and Restore move post id in and out of the trash, and fail with
+	// ErrNoRecord unless userID wrote it.
+	Trash(ctx context.Context, id, userID int) error
+	Restore(ctx context.Context, id, userID int) error
+	// Trashed returns the posts of userID in the trash, most recently deleted
+	// first.
+	Trashed(ctx context.Context, userID int) ([]*Post, error)
+	// PurgeTrashed hard deletes the posts that went to the trash at or before
+	// before and returns how many there were.
+	PurgeTrashed(ctx context.Context, before time.Time) (int, error)
+}
+
+type UserRepository interface {
+	// Insert creates a user with an already hashed password, an empty one
+	// creates an account that can only sign in through an identity provider.
+	Insert(ctx context.Context, name, email, hashedPassword string) (int, error)
+	// Authenticate returns the user registered with email if password matches,
+	// and ErrInvalidCredentials otherwise. Deleted users are found too.
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	// IDByEmail finds deleted users too.
+	IDByEmail(ctx context.Context, email string) (int, error)
+	Get(ctx context.Context, id int) (*User, error)
+	// ScheduleDeletion deletes the user, who is purged once due has passed
+	// unless CancelDeletion restores them first.
+	ScheduleDeletion(ctx context.Context, id int, due time.Time) error
+	CancelDeletion(ctx context.Context, id int) (bool, error)
+	DueForDeletion(ctx context.Context) ([]int, error)
+	Delete(ctx context.Context, id int) error
+	Exist(ctx context.Context, id int) (bool, error)
+}
+
+type SessionRepository interface {
+	Insert(ctx context.Context, userID int, token, ip, userAgent string) (int, error)
+	Touch(ctx context.Context, token string) error
+	// Get returns session id, but only if it belongs to userID.
+	Get(ctx context.Context, id, userID int) (*Session, error)
+	// ListByUser returns the sessions of userID, most recently seen first.
+	ListByUser(ctx context.Context, userID int) ([]*Session, error)
+	Delete(ctx context.Context, id int) error
+	DeleteByToken(ctx context.Context, token string) error
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+type IdentityRepository interface {
+	UserID(ctx context.Context, provider, subject string) (int, error)
+	// Insert links subject at provider to userID, a subject that is already
+	// linked fails with ErrDuplicateIdentity.
+	Insert(ctx context.Context, userID int, provider, subject, email string) error
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// Repositories is a set of repositories that work on the same storage.
+type Repositories struct {
+	Posts      PostRepository
+	Users      UserRepository
+	Sessions   SessionRepository
+	Identities IdentityRepository
+}
+
+type UnitOfWork interface {
+	// Do runs fn with repositories whose changes are kept together if fn
+	// returns nil and undone together otherwise. fn may be run more than once
+	// when the storage asks for a retry, and may call Do again with the
+	// context it got to nest a unit of work that can fail on its own.
+	Do(ctx context.Context, fn func(ctx context.Context, repos Repositories) error) error
+}
+
+var (
+	_ UnitOfWork = (*SQLUnitOfWork)(nil)
+	_ UnitOfWork = (*MemoryUnitOfWork)(nil)
+
+	_ PostRepository     = (*PostModel)(nil)
+	_ UserRepository     = (*UserModel)(nil)
+	_ SessionRepository  = (*SessionModel)(nil)
+	_ IdentityRepository = (*IdentityModel)(nil)
+)
+
+// cached returns where to run the hot queries of a model: its statement cache
+// if it has one, otherwise db.
+func cached(db sqldb.Querier, statements *sqldb.StatementCache) sqldb.Querier {
+	if statements == nil {
+		return db
+	}
+	return statements
+}
+
+// reader returns where to run a read that may lag a little behind writes: a
+// healthy replica, unless ctx asks for the primary or there is none, and
+// otherwise the primary db. The query is prepared on the replica if statements
+// is set, like it is on the primary.
+func reader(ctx context.Context, replicas *sqldb.Replicas, db sqldb.Querier, statements *sqldb.StatementCache) sqldb.Querier {
+	replica, replicaStatements := replicas.Reader(ctx)
+	switch {
+	case replica == nil:
+		return cached(db, statements)
+	case statements == nil:
+		return replica
+	default:
+		return replicaStatements
+	}
+}
+
+// affectedOne turns an update that matched no row into ErrNoRecord.
+func affectedOne(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// withTimeout bounds a query to timeout on top of whatever deadline ctx
+// already has, so a slow query gives up its connection rather than holding it
+// for as long as the client is willing to wait. Zero means no bound.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// clock returns now, or time.Now if now is nil, in UTC and truncated to the
+// second precision of the datetime columns. Timestamps are taken in Go rather
+// than with functions like UTC_TIMESTAMP() that differ between databases.
+func clock(now func() time.Time) time.Time {
+	if now == nil {
+		now = time.Now
+	}
+	return now().UTC().Truncate(time.Second)
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"example.com/practice-rest/internal/sqldb"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// expectedColumns is every column the models read or write, by table.
+var expectedColumns = map[string][]string{
+	"posts":           append(postColumns.names(), "user_id"),
+	"users":           userColumns.names(),
+	"user_sessions":   sessionColumns.names(),
+	"user_identities": identityColumns.names(),
+}
+
+// CheckSchema compares the columns the models expect with the ones in the
+// database, so a schema that drifted from the code is caught at startup
+// rather than by the first request that touches the missing column. Extra
+// columns are fine, the models name the columns they use.
+func CheckSchema(ctx context.Context, db sqldb.Querier, dialect sqldb.Dialect) error {
+	tables := make([]string, 0, len(expectedColumns))
+	for table := range expectedColumns {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var errs []error
+	for _, table := range tables {
+		actual, err := tableColumns(ctx, db, dialect, table)
+		if err != nil {
+			return err
+		}
+		if len(actual) == 0 {
+			errs = append(errs, fmt.Errorf("table %s doesn't exist", table))
+			continue
+		}
+
+		var missing []string
+		for _, name := range expectedColumns[table] {
+			if !actual[name] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			errs = append(errs, fmt.Errorf("table %s is missing columns %s", table, strings.Join(missing, ", ")))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("models: the database schema doesn't match the code: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// tableColumns returns the names of the columns of table, none if it doesn't
+// exist. SQLite has no information_schema, it lists them with a pragma.
+func tableColumns(ctx context.Context, db sqldb.Querier, dialect sqldb.Dialect, table string) (map[string]bool, error) {
+	var query string
+	switch dialect {
+	case sqldb.SQLite:
+		query = `select name from pragma_table_info(?)`
+	case sqldb.Postgres:
+		query = `select column_name from information_schema.columns
+				 where table_schema = current_schema() and table_name = ?`
+	default:
+		query = `select column_name from information_schema.columns
+				 where table_schema = database() and table_name = ?`
+	}
+
+	rows, err := db.QueryContext(ctx, dialect.Rebind(query), table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(name)] = true
+	}
+
+	return columns, rows.Err()
+}
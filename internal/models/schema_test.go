@@ -0,0 +1,46 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	ctx := context.Background()
+	users := newSQLiteUserModel(t)
+
+	if err := CheckSchema(ctx, users.DB, users.Dialect); err != nil {
+		t.Fatalf("CheckSchema() on a migrated database: %v", err)
+	}
+
+	if _, err := users.DB.ExecContext(ctx, `alter table user_identities drop column email`); err != nil {
+		t.Fatal(err)
+	}
+	err := CheckSchema(ctx, users.DB, users.Dialect)
+	if err == nil || !strings.Contains(err.Error(), "user_identities") {
+		t.Errorf("CheckSchema() without user_identities.email = %v, want it reported", err)
+	}
+}
+
+func TestIdentityUserID(t *testing.T) {
+	ctx := context.Background()
+	users := newSQLiteUserModel(t)
+	identities := &IdentityModel{DB: users.DB, Dialect: users.Dialect, QueryTimeout: users.QueryTimeout}
+
+	id, err := users.Insert(ctx, "Amal", "amal@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = identities.Insert(ctx, id, "corp", "subject", "amal@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := identities.UserID(ctx, "corp", "subject"); err != nil || got != id {
+		t.Errorf("UserID() = %d, %v, want %d", got, err, id)
+	}
+	if _, err = identities.UserID(ctx, "corp", "other"); !errors.Is(err, ErrNoRecord) {
+		t.Errorf("UserID() of an unknown subject = %v, want ErrNoRecord", err)
+	}
+}
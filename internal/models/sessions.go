@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"example.com/practice-rest/internal/sqldb"
+	"time"
+)
+
+// Session is an entry of the index that maps scs session tokens to the user
+// that logged in with them. The token itself never leaves the server.
+type Session struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"-"`
+	Token     string    `json:"-"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Current   bool      `json:"current"`
+}
+
+// sessionColumns are the columns of user_sessions a Session is read from.
+var sessionColumns = columns[Session]{
+	{"id", func(s *Session) any { return &s.ID }},
+	{"user_id", func(s *Session) any { return &s.UserID }},
+	{"token", func(s *Session) any { return &s.Token }},
+	{"created", func(s *Session) any { return &s.Created }},
+	{"last_seen", func(s *Session) any { return &s.LastSeen }},
+	{"ip", func(s *Session) any { return &s.IP }},
+	{"user_agent", func(s *Session) any { return &s.UserAgent }},
+}
+
+// SessionModel works on the user_sessions table, see
+// internal/migrations/mysql/0004_create_user_sessions.up.sql.
+type SessionModel struct {
+	DB           sqldb.Querier
+	Dialect      sqldb.Dialect
+	QueryTimeout time.Duration
+}
+
+func (session *SessionModel) Insert(ctx context.Context, userID int, token, ip, userAgent string) (_ int, err error) {
+	query := `insert into user_sessions (user_id, token, created, last_seen, ip, user_agent)
+			  values (?, ?, ?, ?, ?, ?)`
+	ctx, span := startSpan(ctx, session.Dialect, "SessionModel.Insert", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, session.QueryTimeout)
+	defer cancel()
+
+	// The column is only as wide as any sane user agent
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	now := clock(nil)
+	id, err := session.Dialect.InsertID(ctx, session.DB, query, userID, token, now, now, ip, userAgent)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Touch bumps last_seen for token. Writes are skipped while the previous one is
+// less than a minute old so busy clients don't cause a write per request.
+func (session *SessionModel) Touch(ctx context.Context, token string) (err error) {
+	query := `update user_sessions set last_seen = ?
+			  where token = ? and last_seen < ?`
+	ctx, span := startSpan(ctx, session.Dialect, "SessionModel.Touch", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, session.QueryTimeout)
+	defer cancel()
+
+	now := clock(nil)
+	_, err = session.DB.ExecContext(ctx, session.Dialect.Rebind(query), now, token, now.Add(-time.Minute))
+	return err
+}
+
+func (session *SessionModel) Get(ctx context.Context, id, userID int) (_ *Session, err error) {
+	query := `select ` + sessionColumns.list() + ` from user_sessions where id = ? and user_id = ?`
+	ctx, span := startSpan(ctx, session.Dialect, "SessionModel.Get", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, session.QueryTimeout)
+	defer cancel()
+
+	s, err := sessionColumns.scan(session.DB.QueryRowContext(ctx, session.Dialect.Rebind(query), id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (session *SessionModel) ListByUser(ctx context.Context, userID int) (_ []*Session, err error) {
+	query := `select ` + sessionColumns.list() + ` from user_sessions where user_id = ? order by last_seen desc`
+	ctx, span := startSpan(ctx, session.Dialect, "SessionModel.ListByUser", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, session.QueryTimeout)
+	defer cancel()
+
+	rows, err := session.DB.QueryContext(ctx, session.Dialect.Rebind(query), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionColumns.all(rows)
+}
+
+func (session *SessionModel) Delete(ctx context.Context, id int) (err error) {
+	query := `delete from user_sessions where id = ?`
+	ctx, span := startSpan(ctx, session.Dialect, "SessionModel.Delete", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, session.QueryTimeout)
+	defer cancel()
+
+	_, err = session.DB.ExecContext(ctx, session.Dialect.Rebind(query), id)
+	return err
+}
+
+func (session *SessionModel) DeleteByToken(ctx context.Context, token string) (err error) {
+	query := `delete from user_sessions where token = ?`
+	ctx, span := startSpan(ctx, session.Dialect, "SessionModel.DeleteByToken", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, session.QueryTimeout)
+	defer cancel()
+
+	_, err = session.DB.ExecContext(ctx, session.Dialect.Rebind(query), token)
+	return err
+}
+
+func (session *SessionModel) DeleteByUser(ctx context.Context, userID int) (err error) {
+	query := `delete from user_sessions where user_id = ?`
+	ctx, span := startSpan(ctx, session.Dialect, "SessionModel.DeleteByUser", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, session.QueryTimeout)
+	defer cancel()
+
+	_, err = session.DB.ExecContext(ctx, session.Dialect.Rebind(query), userID)
+	return err
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"example.com/practice-rest/internal/sqldb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("example.com/practice-rest/internal/models")
+
+// startSpan starts a client span for a model method running statement. Only the
+// statement template is recorded, never the bound parameters, which may hold
+// personal data.
+func startSpan(ctx context.Context, dialect sqldb.Dialect, operation, statement string) (context.Context, trace.Span) {
+	system := semconv.DBSystemMySQL
+	switch dialect {
+	case sqldb.Postgres:
+		system = semconv.DBSystemPostgreSQL
+	case sqldb.SQLite:
+		system = semconv.DBSystemSqlite
+	}
+
+	return tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			system,
+			semconv.DBStatement(statement),
+			attribute.String("db.operation", operation),
+		),
+	)
+}
+
+// endSpan ends span, marking it failed if err is a real failure. Not finding a
+// row is an expected outcome, not an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, ErrNoRecord) &&
+		!errors.Is(err, ErrInvalidCredentials) && !errors.Is(err, ErrDuplicateEmail) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"example.com/practice-rest/internal/passwords"
+	"example.com/practice-rest/internal/sqldb"
+	"time"
+)
+
+// SQLUnitOfWork runs units of work in a transaction on DB, see sqldb.InTx for
+// the retries and how nesting works. The repositories it hands out are models
+// bound to the transaction. They read from the primary and run unprepared
+// queries.
+type SQLUnitOfWork struct {
+	DB           *sql.DB
+	Dialect      sqldb.Dialect
+	Hasher       *passwords.Hasher
+	QueryTimeout time.Duration
+}
+
+func (u *SQLUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context, repos Repositories) error) error {
+	return u.Dialect.InTx(ctx, u.DB, func(ctx context.Context, tx *sql.Tx) error {
+		return fn(ctx, Repositories{
+			Posts:      &PostModel{DB: tx, Dialect: u.Dialect, QueryTimeout: u.QueryTimeout},
+			Users:      &UserModel{DB: tx, Dialect: u.Dialect, Hasher: u.Hasher, QueryTimeout: u.QueryTimeout},
+			Sessions:   &SessionModel{DB: tx, Dialect: u.Dialect, QueryTimeout: u.QueryTimeout},
+			Identities: &IdentityModel{DB: tx, Dialect: u.Dialect, QueryTimeout: u.QueryTimeout},
+		})
+	})
+}
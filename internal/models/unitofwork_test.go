@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"example.com/practice-rest/internal/passwords"
+	"testing"
+	"time"
+)
+
+func newMemoryRepositories() Repositories {
+	return Repositories{
+		Posts:      NewMemoryPostRepository(),
+		Users:      NewMemoryUserRepository(&passwords.Hasher{Current: passwords.Bcrypt{Cost: 4}}),
+		Sessions:   NewMemorySessionRepository(),
+		Identities: NewMemoryIdentityRepository(),
+	}
+}
+
+func TestMemoryUnitOfWorkUndoesFailedWork(t *testing.T) {
+	ctx := context.Background()
+	repos := newMemoryRepositories()
+	uow := NewMemoryUnitOfWork(repos)
+	errFail := errors.New("fail")
+
+	err := uow.Do(ctx, func(ctx context.Context, repos Repositories) error {
+		id, err := repos.Users.Insert(ctx, "Amal", "amal@example.com", "")
+		if err != nil {
+			return err
+		}
+		repos.Posts.Insert(ctx, id, "title", "content")
+		repos.Sessions.Insert(ctx, id, "token", "10.0.0.1", "test")
+		repos.Identities.Insert(ctx, id, "corp", "subject", "amal@example.com")
+		return errFail
+	})
+	if !errors.Is(err, errFail) {
+		t.Fatalf("Do() = %v, want the error fn returned", err)
+	}
+
+	if _, err = repos.Users.IDByEmail(ctx, "amal@example.com"); !errors.Is(err, ErrNoRecord) {
+		t.Errorf("user of a failed unit of work was kept: %v", err)
+	}
+	if posts, _ := repos.Posts.Latest(ctx); len(posts) != 0 {
+		t.Errorf("posts of a failed unit of work were kept: %v", posts)
+	}
+	if sessions, _ := repos.Sessions.ListByUser(ctx, 1); len(sessions) != 0 {
+		t.Errorf("sessions of a failed unit of work were kept: %v", sessions)
+	}
+	if _, err = repos.Identities.UserID(ctx, "corp", "subject"); !errors.Is(err, ErrNoRecord) {
+		t.Errorf("identity of a failed unit of work was kept: %v", err)
+	}
+
+	// Like auto increment columns, ids handed out by the failed unit of work
+	// aren't reused
+	id, err := repos.Users.Insert(ctx, "Amal", "amal@example.com", "")
+	if err != nil || id != 2 {
+		t.Errorf("Insert() after the rollback = %d, %v, want id 2", id, err)
+	}
+}
+
+func TestMemoryUnitOfWorkKeepsConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	repos := newMemoryRepositories()
+	uow := NewMemoryUnitOfWork(repos)
+
+	outsideID, _ := repos.Users.Insert(ctx, "Outside", "outside@example.com", "")
+	postID, _ := repos.Posts.Insert(ctx, outsideID, "title", "content")
+
+	err := uow.Do(ctx, func(ctx context.Context, units Repositories) error {
+		if _, err := units.Users.Insert(ctx, "Amal", "amal@example.com", ""); err != nil {
+			return err
+		}
+
+		// Another request writes while the unit of work runs
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			repos.Users.Insert(context.Background(), "Other", "other@example.com", "")
+			repos.Posts.Trash(context.Background(), postID, outsideID)
+			repos.Sessions.Insert(context.Background(), outsideID, "token", "10.0.0.1", "test")
+		}()
+		<-done
+
+		return errors.New("fail")
+	})
+	if err == nil {
+		t.Fatal("Do() didn't return fn's error")
+	}
+
+	if _, err = repos.Users.IDByEmail(ctx, "amal@example.com"); !errors.Is(err, ErrNoRecord) {
+		t.Errorf("user of the failed unit of work was kept: %v", err)
+	}
+	if _, err = repos.Users.IDByEmail(ctx, "other@example.com"); err != nil {
+		t.Errorf("user written outside the unit of work was undone: %v", err)
+	}
+	if trash, _ := repos.Posts.Trashed(ctx, outsideID); len(trash) != 1 {
+		t.Errorf("post trashed outside the unit of work was restored: %v", trash)
+	}
+	if sessions, _ := repos.Sessions.ListByUser(ctx, outsideID); len(sessions) != 1 {
+		t.Errorf("session written outside the unit of work was undone: %v", sessions)
+	}
+}
+
+func TestMemoryUnitOfWorkUndoesChangesToExistingRecords(t *testing.T) {
+	ctx := context.Background()
+	repos := newMemoryRepositories()
+	uow := NewMemoryUnitOfWork(repos)
+
+	userID, _ := repos.Users.Insert(ctx, "Amal", "amal@example.com", "")
+	first, _ := repos.Posts.Insert(ctx, userID, "first", "content")
+	second, _ := repos.Posts.Insert(ctx, userID, "second", "content")
+	repos.Identities.Insert(ctx, userID, "corp", "subject", "amal@example.com")
+
+	err := uow.Do(ctx, func(ctx context.Context, repos Repositories) error {
+		repos.Posts.Trash(ctx, first, userID)
+		repos.Posts.DeleteByUser(ctx, userID)
+		repos.Identities.DeleteByUser(ctx, userID)
+		repos.Users.ScheduleDeletion(ctx, userID, time.Now())
+		repos.Users.Delete(ctx, userID)
+		return errors.New("fail")
+	})
+	if err == nil {
+		t.Fatal("Do() didn't return fn's error")
+	}
+
+	user, err := repos.Users.Get(ctx, userID)
+	if err != nil || user.DeletionDue != nil {
+		t.Errorf("Get() of the user = %+v, %v, want them back undeleted", user, err)
+	}
+	posts, _ := repos.Posts.ByUser(ctx, userID)
+	if len(posts) != 2 || posts[0].ID != first || posts[1].ID != second {
+		t.Errorf("ByUser() = %v, want both posts back in order", posts)
+	}
+	if id, err := repos.Identities.UserID(ctx, "corp", "subject"); err != nil || id != userID {
+		t.Errorf("UserID() = %d, %v, want the identity back", id, err)
+	}
+}
+
+func TestMemoryUnitOfWorkNested(t *testing.T) {
+	ctx := context.Background()
+	repos := newMemoryRepositories()
+	uow := NewMemoryUnitOfWork(repos)
+
+	err := uow.Do(ctx, func(ctx context.Context, repos Repositories) error {
+		if _, err := repos.Users.Insert(ctx, "Outer", "outer@example.com", ""); err != nil {
+			return err
+		}
+
+		// Only the inner unit of work's changes are undone
+		err := uow.Do(ctx, func(ctx context.Context, repos Repositories) error {
+			repos.Users.Insert(ctx, "Inner", "inner@example.com", "")
+			return errors.New("inner failed")
+		})
+		if err == nil {
+			t.Error("nested Do() didn't return fn's error")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = repos.Users.IDByEmail(ctx, "outer@example.com"); err != nil {
+		t.Errorf("outer user: %v", err)
+	}
+	if _, err = repos.Users.IDByEmail(ctx, "inner@example.com"); !errors.Is(err, ErrNoRecord) {
+		t.Errorf("inner user was kept: %v", err)
+	}
+}
@@ -1,38 +1,63 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"github.com/go-sql-driver/mysql"
-	"golang.org/x/crypto/bcrypt"
+	"example.com/practice-rest/internal/passwords"
+	"example.com/practice-rest/internal/sqldb"
+	"time"
 )
 
 type User struct {
-	ID             int    `json:"id"`
-	Name           string `json:"name"`
-	Email          string `json:"email"`
-	HashedPassword string `json:"hashed_password"`
-	CreatedAt      string `json:"created_at"`
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	Email          string     `json:"email"`
+	HashedPassword string     `json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeletionDue    *time.Time `json:"deletion_due,omitempty"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+}
+
+// userColumns are the columns of users a User is read from. hashed_password
+// is NULL for accounts that only sign in through an identity provider.
+var userColumns = columns[User]{
+	{"id", func(u *User) any { return &u.ID }},
+	{"name", func(u *User) any { return &u.Name }},
+	{"email", func(u *User) any { return &u.Email }},
+	{"hashed_password", func(u *User) any { return nullString{&u.HashedPassword} }},
+	{"created_at", func(u *User) any { return &u.CreatedAt }},
+	{"deletion_due", func(u *User) any { return nullTime{&u.DeletionDue} }},
+	{"deleted_at", func(u *User) any { return nullTime{&u.DeletedAt} }},
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB           sqldb.Querier
+	Dialect      sqldb.Dialect
+	Hasher       *passwords.Hasher
+	QueryTimeout time.Duration
+	// Statements, if set, keeps the lookup in Authenticate prepared
+	Statements *sqldb.StatementCache
+	// Replicas, if set, serve Get
+	Replicas *sqldb.Replicas
 }
 
-func (user *UserModel) Insert(name, email, password string) (int, error) {
+// Insert creates a user. An empty password creates an account that can only
+// sign in through an external identity provider; hashed_password is left NULL.
+func (user *UserModel) Insert(ctx context.Context, name, email, hashedPassword string) (_ int, err error) {
 	query := `insert into users (name, email, hashed_password, created_at) 
-			  values (?, ?, ?, UTC_TIMESTAMP())`
-
-	result, errQuery := user.DB.Exec(query, name, email, password)
-	id, errResult := result.LastInsertId()
-
-	if err := errors.Join(errQuery, errResult); err != nil {
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			// 1062 is the error number for duplicate entry
-			if mySQLError.Number == 1062 {
-				return 0, ErrDuplicateEmail
-			}
+			  values (?, ?, ?, ?)`
+	ctx, span := startSpan(ctx, user.Dialect, "UserModel.Insert", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, user.QueryTimeout)
+	defer cancel()
+
+	password := sql.NullString{String: hashedPassword, Valid: hashedPassword != ""}
+
+	id, err := user.Dialect.InsertID(ctx, user.DB, query, name, email, password, clock(nil))
+	if err != nil {
+		if user.Dialect.IsUniqueViolation(err) {
+			return 0, ErrDuplicateEmail
 		}
 		return 0, err
 	}
@@ -40,14 +65,21 @@ func (user *UserModel) Insert(name, email, password string) (int, error) {
 	return int(id), nil
 }
 
-func (user *UserModel) Authenticate(email, password string) (int, error) {
+// Authenticate also finds deleted users, logging in is how they restore their
+// account during the grace period.
+func (user *UserModel) Authenticate(ctx context.Context, email, password string) (_ int, err error) {
 	var id int
 	var hashedPassword []byte
 
-	usr := &User{}
-
 	query := `select id, hashed_password from users where email = ?`
-	err := user.DB.QueryRow(query, email).Scan(&usr.Email, &usr.HashedPassword)
+	ctx, span := startSpan(ctx, user.Dialect, "UserModel.Authenticate", query)
+	defer func() { endSpan(span, err) }()
+
+	// Only the queries are bounded by the timeout, not verifying the password
+	queryCtx, cancel := withTimeout(ctx, user.QueryTimeout)
+	defer cancel()
+
+	err = cached(user.DB, user.Statements).QueryRowContext(queryCtx, user.Dialect.Rebind(query), email).Scan(&id, &hashedPassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
@@ -55,12 +87,57 @@ func (user *UserModel) Authenticate(email, password string) (int, error) {
 		return 0, err
 	}
 
-	hashedPassword = []byte(usr.HashedPassword)
+	// Accounts created through an identity provider have no password
+	if len(hashedPassword) == 0 {
+		return 0, ErrInvalidCredentials
+	}
 
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	match, rehash, err := user.Hasher.Verify(password, string(hashedPassword))
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return 0, ErrInvalidCredentials
+		return 0, err
+	}
+	if !match {
+		return 0, ErrInvalidCredentials
+	}
+
+	// The password is only ever available in plain text here, so this is where
+	// hashes made with an outdated algorithm or cost get upgraded. The old hash
+	// is part of the condition so a concurrent password change isn't undone.
+	if rehash {
+		newHash, err := user.Hasher.Hash(password)
+		if err != nil {
+			return 0, err
+		}
+
+		query = `update users set hashed_password = ? where id = ? and hashed_password = ?`
+		rehashCtx, rehashSpan := startSpan(ctx, user.Dialect, "UserModel.Rehash", query)
+		rehashCtx, cancel := withTimeout(rehashCtx, user.QueryTimeout)
+		_, err = user.DB.ExecContext(rehashCtx, user.Dialect.Rebind(query), newHash, id, hashedPassword)
+		cancel()
+		endSpan(rehashSpan, err)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
+
+// IDByEmail returns the id of the user registered with email, deleted or not,
+// so an identity with their email is linked to the account rather than failing
+// to create a second one.
+func (user *UserModel) IDByEmail(ctx context.Context, email string) (_ int, err error) {
+	query := `select id from users where email = ?`
+	ctx, span := startSpan(ctx, user.Dialect, "UserModel.IDByEmail", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, user.QueryTimeout)
+	defer cancel()
+
+	var id int
+	err = user.DB.QueryRowContext(ctx, user.Dialect.Rebind(query), email).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
 		}
 		return 0, err
 	}
@@ -68,10 +145,110 @@ func (user *UserModel) Authenticate(email, password string) (int, error) {
 	return id, nil
 }
 
-func (user *UserModel) Get(id int) (*User, error) {
-	return nil, nil
+func (user *UserModel) Get(ctx context.Context, id int) (_ *User, err error) {
+	query := `select ` + userColumns.list() + ` from users where id = ? and deleted_at is null`
+	ctx, span := startSpan(ctx, user.Dialect, "UserModel.Get", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, user.QueryTimeout)
+	defer cancel()
+
+	u, err := userColumns.scan(reader(ctx, user.Replicas, user.DB, nil).QueryRowContext(ctx, user.Dialect.Rebind(query), id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// ScheduleDeletion deletes the user and marks them to be purged once due has
+// passed.
+func (user *UserModel) ScheduleDeletion(ctx context.Context, id int, due time.Time) (err error) {
+	query := `update users set deleted_at = ?, deletion_due = ? where id = ? and deleted_at is null`
+	ctx, span := startSpan(ctx, user.Dialect, "UserModel.ScheduleDeletion", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, user.QueryTimeout)
+	defer cancel()
+
+	_, err = user.DB.ExecContext(ctx, user.Dialect.Rebind(query), clock(nil), due.UTC(), id)
+	return err
 }
 
-func (user *UserModel) Exist(id int) (bool, error) {
-	return false, nil
+// CancelDeletion restores the user and takes them off the purge schedule, if
+// they were on it.
+func (user *UserModel) CancelDeletion(ctx context.Context, id int) (_ bool, err error) {
+	query := `update users set deleted_at = null, deletion_due = null where id = ? and deletion_due is not null`
+	ctx, span := startSpan(ctx, user.Dialect, "UserModel.CancelDeletion", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, user.QueryTimeout)
+	defer cancel()
+
+	result, err := user.DB.ExecContext(ctx, user.Dialect.Rebind(query), id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// DueForDeletion returns the users whose deletion grace period is over.
+func (user *UserModel) DueForDeletion(ctx context.Context) (_ []int, err error) {
+	query := `select id from users where deletion_due <= ?`
+	ctx, span := startSpan(ctx, user.Dialect, "UserModel.DueForDeletion", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, user.QueryTimeout)
+	defer cancel()
+
+	rows, err := user.DB.QueryContext(ctx, user.Dialect.Rebind(query), clock(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanIDs(rows)
+}
+
+// scanIDs reads a single id column from every row of rows and closes it.
+func scanIDs(rows *sql.Rows) ([]int, error) {
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (user *UserModel) Delete(ctx context.Context, id int) (err error) {
+	query := `delete from users where id = ?`
+	ctx, span := startSpan(ctx, user.Dialect, "UserModel.Delete", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, user.QueryTimeout)
+	defer cancel()
+
+	_, err = user.DB.ExecContext(ctx, user.Dialect.Rebind(query), id)
+	return err
+}
+
+func (user *UserModel) Exist(ctx context.Context, id int) (_ bool, err error) {
+	query := `select exists(select true from users where id = ? and deleted_at is null)`
+	ctx, span := startSpan(ctx, user.Dialect, "UserModel.Exist", query)
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, user.QueryTimeout)
+	defer cancel()
+
+	var exists bool
+	err = user.DB.QueryRowContext(ctx, user.Dialect.Rebind(query), id).Scan(&exists)
+	return exists, err
 }
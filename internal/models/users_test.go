@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"example.com/practice-rest/internal/migrations"
+	"example.com/practice-rest/internal/passwords"
+	"example.com/practice-rest/internal/sqldb"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newSQLiteUserModel(t *testing.T) *UserModel {
+	db, dialect, err := sqldb.Open("sqlite:" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m := &migrations.Migrator{DB: db, Dialect: dialect}
+	if _, err = m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return &UserModel{DB: db, Dialect: dialect, Hasher: &passwords.Hasher{Current: passwords.Bcrypt{Cost: 4}}, QueryTimeout: time.Second}
+}
+
+func TestUserDeletion(t *testing.T) {
+	repos := map[string]func(t *testing.T) UserRepository{
+		"memory": func(*testing.T) UserRepository {
+			return NewMemoryUserRepository(&passwords.Hasher{Current: passwords.Bcrypt{Cost: 4}})
+		},
+		"sqlite": func(t *testing.T) UserRepository { return newSQLiteUserModel(t) },
+	}
+
+	for name, newRepo := range repos {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			users := newRepo(t)
+
+			hashed, _ := passwords.Bcrypt{Cost: 4}.Hash("secret password")
+			id, err := users.Insert(ctx, "Amal", "amal@example.com", hashed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = users.ScheduleDeletion(ctx, id, time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = users.Get(ctx, id); !errors.Is(err, ErrNoRecord) {
+				t.Errorf("Get() of a deleted user = %v, want ErrNoRecord", err)
+			}
+			if exists, _ := users.Exist(ctx, id); exists {
+				t.Error("Exist() reports a deleted user")
+			}
+
+			// Signing in again has to find the account to restore it
+			if got, err := users.Authenticate(ctx, "amal@example.com", "secret password"); err != nil || got != id {
+				t.Errorf("Authenticate() of a deleted user = %d, %v, want %d", got, err, id)
+			}
+			if got, err := users.IDByEmail(ctx, "AMAL@example.com"); err != nil || got != id {
+				t.Errorf("IDByEmail() of a deleted user = %d, %v, want %d", got, err, id)
+			}
+			if _, err = users.Insert(ctx, "Amal", "amal@example.com", ""); !errors.Is(err, ErrDuplicateEmail) {
+				t.Errorf("Insert() with a deleted user's email = %v, want ErrDuplicateEmail", err)
+			}
+
+			cancelled, err := users.CancelDeletion(ctx, id)
+			if err != nil || !cancelled {
+				t.Fatalf("CancelDeletion() = %v, %v, want true", cancelled, err)
+			}
+			user, err := users.Get(ctx, id)
+			if err != nil {
+				t.Fatalf("Get() of a restored user: %v", err)
+			}
+			if user.DeletedAt != nil || user.DeletionDue != nil {
+				t.Errorf("restored user is still deleted: %+v", user)
+			}
+			if cancelled, _ = users.CancelDeletion(ctx, id); cancelled {
+				t.Error("CancelDeletion() cancelled twice")
+			}
+		})
+	}
+}
+
+func TestDueForDeletion(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	users := NewMemoryUserRepository(&passwords.Hasher{Current: passwords.Bcrypt{Cost: 4}})
+	users.Now = func() time.Time { return now }
+
+	id, _ := users.Insert(ctx, "Amal", "amal@example.com", "")
+	users.ScheduleDeletion(ctx, id, now.Add(time.Hour))
+
+	if ids, _ := users.DueForDeletion(ctx); len(ids) != 0 {
+		t.Errorf("DueForDeletion() during the grace period = %v", ids)
+	}
+
+	now = now.Add(time.Hour)
+	if ids, _ := users.DueForDeletion(ctx); len(ids) != 1 || ids[0] != id {
+		t.Errorf("DueForDeletion() once the grace period is over = %v, want [%d]", ids, id)
+	}
+}
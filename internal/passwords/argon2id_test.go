@@ -0,0 +1,37 @@
+package passwords
+
+import "testing"
+
+func TestArgon2idOutdated(t *testing.T) {
+	current := Argon2id{Memory: 64, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+
+	tests := []struct {
+		name string
+		hash Argon2id
+		want bool
+	}{
+		{"same parameters", current, false},
+		{"less memory", Argon2id{Memory: 32, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32}, true},
+		{"more memory", Argon2id{Memory: 128, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32}, false},
+		{"fewer iterations", Argon2id{Memory: 64, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}, true},
+		{"fewer lanes", Argon2id{Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"more lanes", Argon2id{Memory: 64, Iterations: 2, Parallelism: 4, SaltLength: 16, KeyLength: 32}, true},
+		{"shorter salt", Argon2id{Memory: 64, Iterations: 2, Parallelism: 2, SaltLength: 8, KeyLength: 32}, true},
+		{"shorter key", Argon2id{Memory: 64, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 16}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hash.Hash("correct-horse-battery")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := current.Outdated(encoded); got != tt.want {
+				t.Errorf("Outdated(%s) = %v, want %v", encoded, got, tt.want)
+			}
+		})
+	}
+
+	if !current.Outdated("$argon2id$garbage") {
+		t.Error("a malformed hash isn't outdated")
+	}
+}
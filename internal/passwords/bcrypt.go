@@ -0,0 +1,38 @@
+package passwords
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// Bcrypt hashes passwords with bcrypt at Cost. Hashes use bcrypt's own
+// $2a$<cost>$<salt+hash> format, which is what every existing user has.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Recognises(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(hashed), err
+}
+
+func (b Bcrypt) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b Bcrypt) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < b.Cost
+}
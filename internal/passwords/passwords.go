@@ -0,0 +1,59 @@
+// Package passwords hashes and verifies user passwords. Hashes are stored in
+// PHC string format (bcrypt's own $2a$ format for bcrypt) so the algorithm and
+// its parameters travel with every hash, and hashes made with older settings
+// can be recognised and upgraded on the next successful login.
+package passwords
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrUnknownAlgorithm = errors.New("passwords: unknown hash algorithm")
+
+// Algorithm is a single way of hashing passwords with fixed parameters.
+type Algorithm interface {
+	// Recognises reports whether encoded was produced by this algorithm.
+	Recognises(encoded string) bool
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// Outdated reports whether encoded was produced with weaker parameters than
+	// the algorithm is currently configured with, or with different ones where
+	// a parameter isn't a measure of strength.
+	Outdated(encoded string) bool
+}
+
+// Hasher hashes new passwords with Current and still verifies hashes made by
+// any of the Legacy algorithms.
+type Hasher struct {
+	Current Algorithm
+	Legacy  []Algorithm
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.Current.Hash(password)
+}
+
+// Verify checks password against encoded. When it matches, rehash reports
+// whether encoded should be replaced by a fresh hash from Hash.
+func (h *Hasher) Verify(password, encoded string) (match, rehash bool, err error) {
+	if h.Current.Recognises(encoded) {
+		match, err = h.Current.Verify(password, encoded)
+		return match, match && h.Current.Outdated(encoded), err
+	}
+
+	for _, algorithm := range h.Legacy {
+		if algorithm.Recognises(encoded) {
+			match, err = algorithm.Verify(password, encoded)
+			return match, match, err
+		}
+	}
+
+	return false, false, ErrUnknownAlgorithm
+}
+
+// phcFields splits a PHC string into its $-separated fields, dropping the empty
+// one before the leading $.
+func phcFields(encoded string) []string {
+	return strings.Split(strings.TrimPrefix(encoded, "$"), "$")
+}
@@ -0,0 +1,44 @@
+package passwords
+
+import (
+	"bufio"
+	_ "embed"
+	"strings"
+)
+
+// MaxBytes is the longest password accepted. bcrypt refuses to hash longer
+// ones, and the limit holds whatever the algorithm so a password set under
+// argon2id can still be rehashed if bcrypt becomes the current algorithm.
+const MaxBytes = 72
+
+//go:embed common.txt
+var commonList string
+
+var common = loadCommon(commonList)
+
+func loadCommon(list string) map[string]struct{} {
+	set := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		set[strings.ToLower(line)] = struct{}{}
+	}
+
+	return set
+}
+
+// IsCommon reports whether password is on the bundled list of passwords that
+// attackers try first.
+func IsCommon(password string) bool {
+	_, ok := common[strings.ToLower(password)]
+	return ok
+}
+
+// TooLong reports whether password is longer than MaxBytes.
+func TooLong(password string) bool {
+	return len(password) > MaxBytes
+}
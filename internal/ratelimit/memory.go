@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps buckets in process memory, for single-node deployments.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]Bucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]Bucket)}
+}
+
+func (m *MemoryStore) Update(key string, take func(bucket *Bucket) (Bucket, Result)) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var current *Bucket
+	if bucket, ok := m.buckets[key]; ok {
+		current = &bucket
+	}
+
+	bucket, result := take(current)
+	m.buckets[key] = bucket
+
+	return result, nil
+}
+
+func (m *MemoryStore) Sweep(idle time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-idle)
+	for key, bucket := range m.buckets {
+		if bucket.Updated.Before(cutoff) {
+			delete(m.buckets, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Package ratelimit implements token bucket rate limiting over a pluggable
+// bucket store.
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit allows Burst requests at once, refilled at Rate tokens per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute allows n requests per minute, all of which may be used at once.
+func PerMinute(n int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: n}
+}
+
+// Bucket is the stored state of a single token bucket.
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// Result describes the outcome of taking a token, in the terms used by the
+// RateLimit-* response headers.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next token, zero when Allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps buckets. Implementations must apply take atomically per key.
+type Store interface {
+	// Update loads the bucket for key (nil if there is none), replaces it with
+	// whatever take returns and passes through take's result.
+	Update(key string, take func(bucket *Bucket) (Bucket, Result)) (Result, error)
+	// Sweep forgets buckets that haven't been touched for idle.
+	Sweep(idle time.Duration) error
+}
+
+type Limiter struct {
+	Store Store
+	// Now is used instead of time.Now when set.
+	Now func() time.Time
+}
+
+// Take spends a token from the bucket for key if one is available.
+func (l *Limiter) Take(key string, limit Limit) (Result, error) {
+	now := time.Now()
+	if l.Now != nil {
+		now = l.Now()
+	}
+
+	return l.Store.Update(key, func(bucket *Bucket) (Bucket, Result) {
+		return take(bucket, limit, now)
+	})
+}
+
+func take(bucket *Bucket, limit Limit, now time.Time) (Bucket, Result) {
+	tokens := float64(limit.Burst)
+	if bucket != nil {
+		elapsed := now.Sub(bucket.Updated).Seconds()
+		tokens = math.Min(float64(limit.Burst), bucket.Tokens+math.Max(elapsed, 0)*limit.Rate)
+	}
+
+	result := Result{Limit: limit.Burst}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else if limit.Rate > 0 {
+		result.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+
+	result.Remaining = int(tokens)
+	if limit.Rate > 0 {
+		result.Reset = seconds((float64(limit.Burst) - tokens) / limit.Rate)
+	}
+
+	return Bucket{Tokens: tokens, Updated: now}, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
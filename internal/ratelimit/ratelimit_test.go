@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func newLimiter(now *time.Time) *Limiter {
+	return &Limiter{Store: NewMemoryStore(), Now: func() time.Time { return *now }}
+}
+
+func TestTakeAllowsBurstThenLimits(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newLimiter(&now)
+	limit := PerMinute(3)
+
+	for i := 3; i > 0; i-- {
+		result, err := l.Take("ip:10.0.0.1", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != i-1 || result.Limit != 3 {
+			t.Fatalf("request %d: %+v, want allowed with %d remaining", 4-i, result, i-1)
+		}
+	}
+
+	result, _ := l.Take("ip:10.0.0.1", limit)
+	if result.Allowed {
+		t.Fatalf("fourth request was allowed: %+v", result)
+	}
+	if result.RetryAfter != 20*time.Second {
+		t.Errorf("RetryAfter = %v, want 20s for one token at 3 per minute", result.RetryAfter)
+	}
+	if result.Reset != time.Minute {
+		t.Errorf("Reset = %v, want 1m until the bucket is full", result.Reset)
+	}
+
+	if result, _ = l.Take("ip:10.0.0.2", limit); !result.Allowed {
+		t.Errorf("another key shares the bucket: %+v", result)
+	}
+}
+
+func TestTakeRefills(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newLimiter(&now)
+	limit := PerMinute(3)
+
+	for i := 0; i < 3; i++ {
+		l.Take("key", limit)
+	}
+
+	now = now.Add(19 * time.Second)
+	if result, _ := l.Take("key", limit); result.Allowed {
+		t.Fatalf("allowed before a token was refilled: %+v", result)
+	}
+
+	now = now.Add(time.Second)
+	if result, _ := l.Take("key", limit); !result.Allowed {
+		t.Fatalf("not allowed once a token was refilled: %+v", result)
+	}
+
+	// A long pause refills the bucket, but never beyond the burst
+	now = now.Add(time.Hour)
+	result, _ := l.Take("key", limit)
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf("after a long pause: %+v, want allowed with 2 remaining", result)
+	}
+}
+
+func TestTakeIgnoresClockGoingBack(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newLimiter(&now)
+	limit := PerMinute(1)
+
+	l.Take("key", limit)
+	now = now.Add(-time.Hour)
+	if result, _ := l.Take("key", limit); result.Allowed {
+		t.Errorf("a clock going back refilled the bucket: %+v", result)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	store := NewMemoryStore()
+	store.Update("old", func(*Bucket) (Bucket, Result) {
+		return Bucket{Updated: time.Now().Add(-2 * time.Hour)}, Result{}
+	})
+	store.Update("new", func(*Bucket) (Bucket, Result) {
+		return Bucket{Updated: time.Now()}, Result{}
+	})
+
+	if err := store.Sweep(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.buckets["old"]; ok {
+		t.Error("idle bucket was kept")
+	}
+	if _, ok := store.buckets["new"]; !ok {
+		t.Error("recent bucket was dropped")
+	}
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"example.com/practice-rest/internal/sqldb"
+	"time"
+)
+
+// SQLStore keeps buckets in the database so limits hold across every
+// instance. Each update locks the bucket's row for the duration of a short
+// transaction, or the whole database on SQLite, which has no row locks. Its
+// table is created by the 0007_create_rate_limits migration.
+type SQLStore struct {
+	DB      *sql.DB
+	Dialect sqldb.Dialect
+}
+
+// Update creates the bucket's row first if it is missing, so there is always a
+// row to lock. Selecting a missing row for update locks nothing, and concurrent
+// first requests for a key would each start from a full bucket.
+func (m *SQLStore) Update(key string, take func(bucket *Bucket) (Bucket, Result)) (Result, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	// The row is updated with the real bucket below, in the same transaction
+	query := `insert into rate_limits (bucket_key, tokens, updated) values (?, 0, ?)
+			  on duplicate key update bucket_key = bucket_key`
+	if m.Dialect == sqldb.Postgres || m.Dialect == sqldb.SQLite {
+		query = `insert into rate_limits (bucket_key, tokens, updated) values (?, 0, ?)
+				 on conflict (bucket_key) do nothing`
+	}
+	inserted, err := tx.Exec(m.Dialect.Rebind(query), key, time.Now().UTC())
+	if err != nil {
+		return Result{}, err
+	}
+	created, err := inserted.RowsAffected()
+	if err != nil {
+		return Result{}, err
+	}
+
+	// A row this transaction created is a new bucket, any other is locked
+	// until the transaction ends
+	var current *Bucket
+	if created == 0 {
+		query = `select tokens, updated from rate_limits where bucket_key = ?`
+		if m.Dialect != sqldb.SQLite {
+			query += ` for update`
+		}
+
+		stored := Bucket{}
+		err = tx.QueryRow(m.Dialect.Rebind(query), key).Scan(&stored.Tokens, &stored.Updated)
+		if err != nil {
+			return Result{}, err
+		}
+		current = &stored
+	}
+
+	bucket, result := take(current)
+
+	query = `update rate_limits set tokens = ?, updated = ? where bucket_key = ?`
+	if _, err = tx.Exec(m.Dialect.Rebind(query), bucket.Tokens, bucket.Updated.UTC(), key); err != nil {
+		return Result{}, err
+	}
+
+	return result, tx.Commit()
+}
+
+func (m *SQLStore) Sweep(idle time.Duration) error {
+	query := `delete from rate_limits where updated < ?`
+
+	_, err := m.DB.Exec(m.Dialect.Rebind(query), time.Now().Add(-idle).UTC())
+	return err
+}
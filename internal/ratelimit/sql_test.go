@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"example.com/practice-rest/internal/migrations"
+	"example.com/practice-rest/internal/sqldb"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newSQLStore opens a SQLite store with deferred transactions, which only lock
+// the database at their first write like MySQL and Postgres only lock a row
+// that exists, so a store that reads before it writes races.
+func newSQLStore(t *testing.T) *SQLStore {
+	db, dialect, err := sqldb.Open("sqlite:" + filepath.Join(t.TempDir(), "test.db") + "?_txlock=deferred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m := &migrations.Migrator{DB: db, Dialect: dialect}
+	if _, err = m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return &SQLStore{DB: db, Dialect: dialect}
+}
+
+func TestSQLStoreConcurrentNewKey(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := &Limiter{Store: newSQLStore(t), Now: func() time.Time { return now }}
+	limit := PerMinute(5)
+
+	// Concurrent first requests for a key share one bucket rather than each
+	// starting from a full one
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			result, err := l.Take("ip:10.0.0.1", limit)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := allowed.Load(); got != 5 {
+		t.Errorf("%d of 20 concurrent requests were allowed, want the burst of 5", got)
+	}
+}
+
+func TestSQLStoreSweep(t *testing.T) {
+	store := newSQLStore(t)
+	l := &Limiter{Store: store}
+	limit := PerMinute(1)
+
+	l.Take("key", limit)
+	if result, _ := l.Take("key", limit); result.Allowed {
+		t.Fatalf("second request was allowed: %+v", result)
+	}
+
+	// A swept key starts over with a full bucket
+	if err := store.Sweep(-time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := l.Take("key", limit); !result.Allowed {
+		t.Errorf("first request after the sweep: %+v, want allowed", result)
+	}
+}
@@ -0,0 +1,101 @@
+// Package sessionstore is an scs.Store for the databases scs has no store of
+// its own for in this module. It uses the same sessions table layout as the
+// upstream scs stores, so switching between them keeps existing sessions.
+package sessionstore
+
+import (
+	"database/sql"
+	"errors"
+	"example.com/practice-rest/internal/sqldb"
+	"log/slog"
+	"time"
+)
+
+// SQL keeps scs session data in the sessions table created by the
+// 0003_create_sessions migration.
+type SQL struct {
+	db          *sql.DB
+	dialect     sqldb.Dialect
+	stopCleanup chan bool
+}
+
+// New returns a store that deletes expired sessions every five minutes, like
+// the upstream stores do.
+func New(db *sql.DB, dialect sqldb.Dialect) *SQL {
+	return NewWithCleanupInterval(db, dialect, 5*time.Minute)
+}
+
+// NewWithCleanupInterval returns a store that deletes expired sessions every
+// interval, or never if interval is zero.
+func NewWithCleanupInterval(db *sql.DB, dialect sqldb.Dialect, interval time.Duration) *SQL {
+	s := &SQL{db: db, dialect: dialect}
+	if interval > 0 {
+		s.stopCleanup = make(chan bool)
+		go s.startCleanup(interval)
+	}
+	return s
+}
+
+func (s *SQL) Find(token string) ([]byte, bool, error) {
+	query := `select data from sessions where token = ? and expiry > ?`
+
+	var b []byte
+	err := s.db.QueryRow(s.dialect.Rebind(query), token, time.Now().UTC()).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+func (s *SQL) Commit(token string, b []byte, expiry time.Time) error {
+	query := `insert into sessions (token, data, expiry) values (?, ?, ?)
+			  on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+	if s.dialect == sqldb.MySQL || s.dialect == "" {
+		query = `insert into sessions (token, data, expiry) values (?, ?, ?)
+				 on duplicate key update data = values(data), expiry = values(expiry)`
+	}
+
+	_, err := s.db.Exec(s.dialect.Rebind(query), token, b, expiry.UTC())
+	return err
+}
+
+func (s *SQL) Delete(token string) error {
+	query := `delete from sessions where token = ?`
+
+	_, err := s.db.Exec(s.dialect.Rebind(query), token)
+	return err
+}
+
+func (s *SQL) deleteExpired() error {
+	query := `delete from sessions where expiry < ?`
+
+	_, err := s.db.Exec(s.dialect.Rebind(query), time.Now().UTC())
+	return err
+}
+
+func (s *SQL) startCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.deleteExpired(); err != nil {
+				slog.Error("Session Cleanup Failed", "error", err)
+			}
+		case <-s.stopCleanup:
+			return
+		}
+	}
+}
+
+// StopCleanup stops the cleanup goroutine, for tests and short-lived
+// programs that open and close many stores.
+func (s *SQL) StopCleanup() {
+	if s.stopCleanup != nil {
+		s.stopCleanup <- true
+	}
+}
@@ -0,0 +1,113 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Replicas spreads reads that can tolerate replication lag over read replicas
+// of the primary database, round-robin over the ones that passed their last
+// health check. Writes, and reads that must see them, stay on the primary.
+type Replicas struct {
+	nodes []*replica
+	next  atomic.Uint64
+}
+
+type replica struct {
+	db         *sql.DB
+	statements *StatementCache
+	healthy    atomic.Bool
+}
+
+// OpenReplicas opens the replicas named by dsns, which must speak dialect like
+// the primary does. A replica that can't be reached yet doesn't fail startup,
+// it just isn't used until a Check succeeds.
+func OpenReplicas(ctx context.Context, dsns []string, dialect Dialect) (*Replicas, error) {
+	if dialect == SQLite {
+		return nil, errors.New("sqldb: sqlite has no replicas")
+	}
+
+	r := &Replicas{}
+	for i, dsn := range dsns {
+		replicaDialect, driver, source, err := parse(dsn)
+		if err == nil && replicaDialect != dialect {
+			err = fmt.Errorf("is %s, the primary is %s", replicaDialect, dialect)
+		}
+		var db *sql.DB
+		if err == nil {
+			db, err = sql.Open(driver, source)
+		}
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("sqldb: replica %d: %w", i+1, err)
+		}
+		r.nodes = append(r.nodes, &replica{db: db, statements: NewStatementCache(db)})
+	}
+
+	r.Check(ctx)
+	return r, nil
+}
+
+// SetPool applies the primary's pool limits to every replica.
+func (r *Replicas) SetPool(configure func(db *sql.DB)) {
+	for _, node := range r.nodes {
+		configure(node.db)
+	}
+}
+
+// Check pings every replica and only routes reads to the ones that answer.
+// The error lists the replicas that didn't.
+func (r *Replicas) Check(ctx context.Context) error {
+	var errs []error
+	for i, node := range r.nodes {
+		err := node.db.PingContext(ctx)
+		node.healthy.Store(err == nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sqldb: replica %d: %w", i+1, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Reader returns the replica the next lagging read should go to and its
+// statement cache, or a nil *sql.DB if it should go to the primary: there are
+// no healthy replicas, r is nil or ctx came from WithPrimary.
+func (r *Replicas) Reader(ctx context.Context) (*sql.DB, *StatementCache) {
+	if r == nil || len(r.nodes) == 0 || usePrimary(ctx) {
+		return nil, nil
+	}
+
+	start := r.next.Add(1)
+	for i := range r.nodes {
+		node := r.nodes[(start+uint64(i))%uint64(len(r.nodes))]
+		if node.healthy.Load() {
+			return node.db, node.statements
+		}
+	}
+	return nil, nil
+}
+
+// Close closes every replica.
+func (r *Replicas) Close() error {
+	var errs []error
+	for _, node := range r.nodes {
+		errs = append(errs, node.statements.Close(), node.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+type primaryKey struct{}
+
+// WithPrimary returns a context whose reads all go to the primary, for
+// requests that have to see writes the replicas may not have caught up with.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
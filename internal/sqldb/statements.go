@@ -0,0 +1,96 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// StatementCache prepares queries the first time they run and reuses the
+// statements afterwards, so hot queries are parsed and planned once rather
+// than on every request. With MySQL it also saves the round trips the driver
+// otherwise spends preparing and closing a statement for every query with
+// arguments.
+//
+// Statements are prepared on the pool, not a connection. database/sql prepares
+// them again on every connection they end up running on, including the ones
+// replacing lost connections, so the cache only has to remember them. A query
+// that fails to prepare isn't cached and runs unprepared instead, which
+// reports the error the same way it would have without the cache.
+type StatementCache struct {
+	db *sql.DB
+
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+func NewStatementCache(db *sql.DB) *StatementCache {
+	return &StatementCache{db: db, stmts: map[string]*sql.Stmt{}}
+}
+
+func (c *StatementCache) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.RLock()
+	stmt, ok := c.stmts[query]
+	c.mu.RUnlock()
+	if ok {
+		return stmt, nil
+	}
+
+	// Preparing is a round trip to the database, so it happens without the
+	// lock rather than holding up every other cached query
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another request may have prepared it at the same time, the first one
+	// published is kept
+	if cached, ok := c.stmts[query]; ok {
+		stmt.Close()
+		return cached, nil
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (c *StatementCache) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	stmt, err := c.stmt(ctx, query)
+	if err != nil {
+		return c.db.QueryContext(ctx, query, args...)
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+func (c *StatementCache) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	stmt, err := c.stmt(ctx, query)
+	if err != nil {
+		return c.db.QueryRowContext(ctx, query, args...)
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+func (c *StatementCache) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := c.stmt(ctx, query)
+	if err != nil {
+		return c.db.ExecContext(ctx, query, args...)
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// Close closes every cached statement. Use the cache no more afterwards.
+func (c *StatementCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for query, stmt := range c.stmts {
+		if errClose := stmt.Close(); err == nil {
+			err = errClose
+		}
+		delete(c.stmts, query)
+	}
+	return err
+}
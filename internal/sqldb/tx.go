@@ -0,0 +1,121 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"time"
+)
+
+// Querier runs queries. *sql.DB, *sql.Tx and *StatementCache all are one, so
+// code written against it works both inside and outside a transaction.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// txAttempts is how often InTx runs a transaction that keeps failing on a
+// deadlock or serialization failure before giving up.
+const txAttempts = 3
+
+type txKey struct{}
+
+type txState struct {
+	tx         *sql.Tx
+	savepoints int
+}
+
+// InTx runs fn in a transaction on db, committing it if fn returns nil and
+// rolling it back otherwise. The context fn gets carries the transaction.
+//
+// A transaction that fails because the database picked it as a deadlock
+// victim or couldn't serialize it is run again from the start, so fn must not
+// have effects outside the transaction that can't be repeated.
+//
+// Calling InTx again with the context fn got nests: the inner fn runs inside
+// a savepoint of the same transaction, and only its own changes are undone if
+// it fails. Deadlocks abort the whole transaction, so they are only retried by
+// the outermost InTx.
+func (d Dialect) InTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return state.savepoint(ctx, fn)
+	}
+
+	var err error
+	for attempt := 1; attempt <= txAttempts; attempt++ {
+		err = d.runTx(ctx, db, fn)
+		if err == nil || !d.IsRetryable(err) {
+			return err
+		}
+
+		// Back off a little so the transactions that collided don't do so again
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt*attempt) * 10 * time.Millisecond):
+		}
+	}
+	return err
+}
+
+func (d Dialect) runTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(context.WithValue(ctx, txKey{}, &txState{tx: tx}), tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *txState) savepoint(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	s.savepoints++
+	name := fmt.Sprintf("sp_%d", s.savepoints)
+
+	if _, err := s.tx.ExecContext(ctx, "savepoint "+name); err != nil {
+		return err
+	}
+
+	if err := fn(ctx, s.tx); err != nil {
+		// The rollback fails if the database already aborted the transaction,
+		// which the caller learns from err either way
+		s.tx.ExecContext(ctx, "rollback to savepoint "+name)
+		return err
+	}
+
+	_, err := s.tx.ExecContext(ctx, "release savepoint "+name)
+	return err
+}
+
+// IsRetryable reports whether err means the transaction was aborted because
+// of concurrent transactions and may succeed if run again.
+func (d Dialect) IsRetryable(err error) bool {
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		// 1213 is a deadlock, 1205 a lock wait timeout
+		return mySQLError.Number == 1213 || mySQLError.Number == 1205
+	}
+
+	var pgError *pgconn.PgError
+	if errors.As(err, &pgError) {
+		// serialization_failure and deadlock_detected
+		return pgError.Code == "40001" || pgError.Code == "40P01"
+	}
+
+	var sqliteError *sqlite.Error
+	if errors.As(err, &sqliteError) {
+		// Extended codes keep the primary code in the low byte
+		return sqliteError.Code()&0xff == sqlite3.SQLITE_BUSY
+	}
+
+	return false
+}
@@ -0,0 +1,233 @@
+package sso
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"golang.org/x/oauth2"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockProvider is a minimal OpenID Connect provider: discovery, signing keys
+// and a token endpoint that checks the PKCE verifier. Authorization is done by
+// authorize, which stands in for the user logging in at the provider.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+// grant is what the provider remembers about an authorization code.
+type grant struct {
+	challenge string
+	nonce     string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockProvider{t: t, key: key, codes: map[string]grant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/keys", m.keys)
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+func (m *mockProvider) discovery(res http.ResponseWriter, req *http.Request) {
+	json.NewEncoder(res).Encode(map[string]any{
+		"issuer":                                m.server.URL,
+		"authorization_endpoint":                m.server.URL + "/authorize",
+		"token_endpoint":                        m.server.URL + "/token",
+		"jwks_uri":                              m.server.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (m *mockProvider) keys(res http.ResponseWriter, req *http.Request) {
+	json.NewEncoder(res).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize plays the user approving the login at authURL and returns the
+// code the provider would send to the callback.
+func (m *mockProvider) authorize(authURL string) (code, state string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		m.t.Fatalf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code = "code-" + query.Get("state")
+	m.codes[code] = grant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	return code, query.Get("state")
+}
+
+func (m *mockProvider) token(res http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+
+	m.mu.Lock()
+	g, ok := m.codes[req.PostForm.Get("code")]
+	delete(m.codes, req.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(req.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(res).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken := m.sign(map[string]any{
+		"iss":            m.server.URL,
+		"aud":            "practice-rest",
+		"sub":            "user-1",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          "amal@example.com",
+		"email_verified": true,
+		"name":           "Amal",
+	})
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+// sign returns claims as an RS256 JWT.
+func (m *mockProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (m *mockProvider) provider(t *testing.T) *Provider {
+	p, err := New(context.Background(), Config{
+		Name:        "mock",
+		Issuer:      m.server.URL,
+		ClientID:    "practice-rest",
+		RedirectURL: "https://localhost:5000/auth/mock/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestFinish(t *testing.T) {
+	mock := newMockProvider(t)
+	p := mock.provider(t)
+
+	authURL, flow, err := p.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := mock.authorize(authURL)
+
+	identity, err := p.Finish(context.Background(), flow, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Identity{Provider: "mock", Subject: "user-1", Email: "amal@example.com", EmailVerified: true, Name: "Amal"}
+	if *identity != want {
+		t.Errorf("Finish() = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestFinishRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes what the callback hands to Finish
+		tamper func(flow *Flow, state *string)
+		// want is nil when the provider itself refuses the code
+		want error
+	}{
+		{"state from another login", func(flow *Flow, state *string) { *state = "forged" }, ErrInvalidState},
+		{"no state in the session", func(flow *Flow, state *string) { flow.State, *state = "", "" }, ErrInvalidState},
+		{"nonce from another login", func(flow *Flow, state *string) { flow.Nonce = "other" }, ErrInvalidNonce},
+		{"verifier from another login", func(flow *Flow, state *string) { flow.Verifier = "wrong-verifier-wrong-verifier-wrong-verifier" }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockProvider(t)
+			p := mock.provider(t)
+
+			authURL, flow, err := p.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, state := mock.authorize(authURL)
+			tt.tamper(&flow, &state)
+
+			identity, err := p.Finish(context.Background(), flow, state, code)
+			if err == nil {
+				t.Fatalf("Finish() = %+v, want an error", identity)
+			}
+			var retrieveErr *oauth2.RetrieveError
+			switch {
+			case tt.want != nil && !errors.Is(err, tt.want):
+				t.Errorf("Finish() error = %v, want %v", err, tt.want)
+			case tt.want == nil && !errors.As(err, &retrieveErr):
+				t.Errorf("Finish() error = %v, want the token endpoint to refuse the code", err)
+			}
+		})
+	}
+}
+
+func TestBeginIsUnique(t *testing.T) {
+	p := newMockProvider(t).provider(t)
+
+	_, first, _ := p.Begin()
+	_, second, _ := p.Begin()
+	if first.State == second.State || first.Nonce == second.Nonce || first.Verifier == second.Verifier {
+		t.Errorf("two logins share values: %+v and %+v", first, second)
+	}
+}
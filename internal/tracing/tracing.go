@@ -0,0 +1,103 @@
+// Package tracing sets up OpenTelemetry tracing for the HTTP server and the
+// models layer.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"os"
+)
+
+// Config selects where spans go.
+type Config struct {
+	// Exporter is "none", "stdout" or "otlp".
+	Exporter string
+	// Endpoint is the OTLP/HTTP collector address (host:port). When empty the
+	// OTEL_EXPORTER_OTLP_* environment variables apply.
+	Endpoint string
+	Insecure bool
+	// SampleRatio is the share of new traces recorded. Requests arriving with a
+	// sampled traceparent are always recorded.
+	SampleRatio float64
+	ServiceName string
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. The returned function flushes pending spans and must be called
+// before the process exits.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	// traceparent is propagated even with tracing off, so this service doesn't
+	// break traces passing through it.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		var options []otlptracehttp.Option
+		if config.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(config.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for every request, continuing the trace from
+// the incoming traceparent header if there is one.
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "HTTP",
+		otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+			return req.Method
+		}),
+	)
+}
+
+// Route names the request's server span after the router pattern it matched,
+// which keeps span names low-cardinality.
+func Route(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		span := trace.SpanFromContext(req.Context())
+		span.SetName(req.Method + " " + pattern)
+		span.SetAttributes(semconv.HTTPRoute(pattern))
+		next.ServeHTTP(res, req)
+	})
+}
@@ -1,10 +1,348 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
 )
 
-type Application struct {
-	ErrorLog *log.Logger
-	InfoLog  *log.Logger
+// Config is everything the web server can be configured with. Each setting
+// can come from, in increasing precedence, its default, the YAML config file,
+// an environment variable and a command-line flag.
+//
+// The yaml tag names the setting in the config file, the flag tag names the
+// command-line flag and the environment variable is the flag name upper cased
+// with an EnvPrefix, so -lockout-store is PRACTICE_LOCKOUT_STORE. Settings
+// tagged secret are redacted when the config is printed.
+type Config struct {
+	Server    Server    `yaml:"server"`
+	TLS       TLS       `yaml:"tls"`
+	Database  Database  `yaml:"database"`
+	Session   Session   `yaml:"session"`
+	Lockout   Lockout   `yaml:"lockout"`
+	SSO       SSO       `yaml:"sso"`
+	Deletion  Deletion  `yaml:"deletion"`
+	Passwords Passwords `yaml:"passwords"`
+	CORS      CORS      `yaml:"cors"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Metrics   Metrics   `yaml:"metrics"`
+	Tracing   Tracing   `yaml:"tracing"`
+	Log       Log       `yaml:"log"`
+	Health    Health    `yaml:"health"`
+	Shutdown  Shutdown  `yaml:"shutdown"`
+}
+
+type Server struct {
+	Addr         string        `yaml:"addr" flag:"addr" usage:"HTTP network address to start the server"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" flag:"read-timeout" usage:"How long reading a whole request may take"`
+	WriteTimeout time.Duration `yaml:"write_timeout" flag:"write-timeout" usage:"How long writing a response may take"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" flag:"idle-timeout" usage:"How long an idle keep-alive connection is kept open"`
+	MaxBodyBytes int64         `yaml:"max_body_bytes" flag:"max-body-bytes" usage:"Largest request body accepted, in bytes"`
+}
+
+// TLS is served when both files are set or Dev is on, otherwise the server
+// speaks plain HTTP and is expected to sit behind something that terminates
+// TLS. The certificate files are checked for changes every ReloadInterval.
+type TLS struct {
+	CertFile       string        `yaml:"cert_file" flag:"tls-cert" usage:"PEM certificate chain file"`
+	KeyFile        string        `yaml:"key_file" flag:"tls-key" usage:"PEM private key file"`
+	ReloadInterval time.Duration `yaml:"reload_interval" flag:"tls-reload-interval" usage:"How often the certificate files are checked for changes"`
+	Dev            bool          `yaml:"dev" flag:"dev-tls" usage:"Serve TLS with a self-signed certificate generated at startup, for local use only"`
+	ClientAuth     string        `yaml:"client_auth" flag:"tls-client-auth" usage:"Client certificates (none, optional or require)"`
+	ClientCAFile   string        `yaml:"client_ca_file" flag:"tls-client-ca" usage:"PEM file of the CAs client certificates must chain to"`
+	RedirectAddr   string        `yaml:"redirect_addr" flag:"tls-redirect-addr" usage:"Network address redirecting plain HTTP to HTTPS, empty to disable"`
+	HSTSMaxAge     time.Duration `yaml:"hsts_max_age" flag:"hsts-max-age" usage:"Strict-Transport-Security max-age sent over TLS, 0 to disable"`
+}
+
+// Enabled reports whether the API is served over TLS.
+func (t TLS) Enabled() bool {
+	return t.Dev || t.CertFile != ""
+}
+
+type Database struct {
+	DSN             string        `yaml:"dsn" flag:"dsn" usage:"Database to use: a MySQL data source name, a postgres:// URL, sqlite:FILE for a local database file, or memory to keep everything in process" secret:"true"`
+	MigrateOnStart  bool          `yaml:"migrate_on_start" flag:"migrate-on-start" usage:"Apply pending migrations before serving, otherwise use the migrate command"`
+	QueryTimeout    time.Duration `yaml:"query_timeout" flag:"db-query-timeout" usage:"How long a single query may take, 0 for no limit"`
+	MaxOpenConns    int           `yaml:"max_open_conns" flag:"db-max-open-conns" usage:"Most connections open at once, 0 for no limit"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" flag:"db-max-idle-conns" usage:"Most idle connections kept for reuse"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" flag:"db-conn-max-lifetime" usage:"How long a connection is reused before it is closed, 0 for no limit"`
+	// Replicas take the same forms as DSN and must be the same database
+	Replicas             []string      `yaml:"replicas" flag:"db-replicas" usage:"Read replicas of the database, comma separated" secret:"true"`
+	ReplicaCheckInterval time.Duration `yaml:"replica_check_interval" flag:"db-replica-check-interval" usage:"How often replicas are health-checked"`
+	ReadYourWrites       time.Duration `yaml:"read_your_writes" flag:"db-read-your-writes" usage:"How long after a logged-in user writes their reads stay on the primary"`
+}
+
+type Session struct {
+	Lifetime     time.Duration `yaml:"lifetime" flag:"session-lifetime" usage:"How long a login session lasts"`
+	CookieSecure bool          `yaml:"cookie_secure" flag:"session-cookie-secure" usage:"Only send the session cookie over HTTPS"`
+}
+
+// Lockout is the brute-force protection for the login endpoint, see
+// internal/lockout.
+type Lockout struct {
+	Store            string        `yaml:"store" flag:"lockout-store" usage:"Where failed login counters are kept (database or memory), the database unless the dsn is memory"`
+	AccountThreshold int           `yaml:"account_threshold" flag:"lockout-account-threshold" usage:"Failed logins per account before it is locked"`
+	IPThreshold      int           `yaml:"ip_threshold" flag:"lockout-ip-threshold" usage:"Failed logins per IP address before it is locked"`
+	BaseDelay        time.Duration `yaml:"base_delay" flag:"lockout-base-delay" usage:"Lockout applied when a threshold is reached, doubled on each further failure"`
+	MaxDelay         time.Duration `yaml:"max_delay" flag:"lockout-max-delay" usage:"Upper bound for the lockout"`
+	Window           time.Duration `yaml:"window" flag:"lockout-window" usage:"How long failed logins are remembered"`
+}
+
+type SSO struct {
+	ConfigFile string `yaml:"config_file" flag:"sso-config" usage:"JSON file listing the OpenID Connect providers to allow sign in with"`
+}
+
+type Deletion struct {
+	Grace     time.Duration `yaml:"grace" flag:"deletion-grace" usage:"How long a deleted account can still be restored by logging in"`
+	Policy    string        `yaml:"policy" flag:"deletion-policy" usage:"What happens to a deleted user's posts (anonymise or delete)"`
+	Retention time.Duration `yaml:"retention" flag:"deletion-retention" usage:"How long deleted posts stay in the trash before they are purged"`
+	// PurgeInterval is how often the deletion worker runs, "web purge"
+	// empties the trash on demand.
+	PurgeInterval time.Duration `yaml:"purge_interval" flag:"deletion-purge-interval" usage:"How often deleted accounts past their grace period and posts past the retention window are purged"`
+}
+
+// Passwords picks the algorithm new passwords are hashed with, hashes made
+// with the other algorithm or weaker parameters are upgraded on the next login.
+type Passwords struct {
+	Algorithm         string `yaml:"algorithm" flag:"password-algorithm" usage:"Algorithm used to hash new passwords (argon2id or bcrypt)"`
+	Argon2Memory      uint32 `yaml:"argon2_memory" flag:"argon2-memory" usage:"Argon2id memory in KiB"`
+	Argon2Iterations  uint32 `yaml:"argon2_iterations" flag:"argon2-iterations" usage:"Argon2id passes over the memory"`
+	Argon2Parallelism uint8  `yaml:"argon2_parallelism" flag:"argon2-parallelism" usage:"Argon2id lanes"`
+	BcryptCost        int    `yaml:"bcrypt_cost" flag:"bcrypt-cost" usage:"bcrypt cost"`
+}
+
+// CORS is for browser clients on other origins, lists are comma separated in
+// flags and environment variables.
+type CORS struct {
+	Origins     []string      `yaml:"origins" flag:"cors-origins" usage:"Origins allowed to call the API, e.g. https://app.example.com,https://*.example.com"`
+	Methods     []string      `yaml:"methods" flag:"cors-methods" usage:"Methods allowed in cross-origin requests"`
+	Headers     []string      `yaml:"headers" flag:"cors-headers" usage:"Request headers allowed in cross-origin requests"`
+	Expose      []string      `yaml:"expose" flag:"cors-expose" usage:"Response headers readable by cross-origin clients"`
+	Credentials bool          `yaml:"credentials" flag:"cors-credentials" usage:"Allow cross-origin requests to send the session cookie"`
+	MaxAge      time.Duration `yaml:"max_age" flag:"cors-max-age" usage:"How long browsers may cache preflight responses"`
+}
+
+type RateLimit struct {
+	Store string `yaml:"store" flag:"ratelimit-store" usage:"Where rate limit buckets are kept (memory or database)"`
+	IP    int    `yaml:"ip" flag:"ratelimit-ip" usage:"Requests per minute per IP address"`
+	Auth  int    `yaml:"auth" flag:"ratelimit-auth" usage:"Signup and login requests per minute per IP address"`
+	User  int    `yaml:"user" flag:"ratelimit-user" usage:"Requests per minute per logged-in user"`
+}
+
+type Metrics struct {
+	Addr       string `yaml:"addr" flag:"metrics-addr" usage:"Admin network address serving /metrics, empty to disable"`
+	AdminToken string `yaml:"admin_token" flag:"admin-token" usage:"Bearer token admin actions such as POST /admin/purge on the admin listener require, empty to disable them" secret:"true"`
+}
+
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" flag:"trace-exporter" usage:"Where trace spans are sent (none, stdout or otlp)"`
+	Endpoint    string  `yaml:"endpoint" flag:"trace-endpoint" usage:"OTLP/HTTP collector address, defaults to OTEL_EXPORTER_OTLP_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" flag:"trace-insecure" usage:"Send spans to the OTLP collector over plain HTTP"`
+	SampleRatio float64 `yaml:"sample_ratio" flag:"trace-sample-ratio" usage:"Share of new traces recorded"`
+}
+
+type Log struct {
+	Format string `yaml:"format" flag:"log-format" usage:"Log output format (json or text)"`
+	Level  string `yaml:"level" flag:"log-level" usage:"Minimum level logged (debug, info, warn or error)"`
+}
+
+type Health struct {
+	ReadinessTimeout time.Duration `yaml:"readiness_timeout" flag:"readiness-timeout" usage:"Timeout for each readiness check"`
+}
+
+type Shutdown struct {
+	Delay   time.Duration `yaml:"delay" flag:"shutdown-delay" usage:"How long to report not ready before closing listeners on SIGTERM"`
+	Timeout time.Duration `yaml:"timeout" flag:"shutdown-timeout" usage:"How long in-flight requests get to finish on SIGTERM"`
+}
+
+// Default returns the settings used when nothing else is configured.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:         "localhost:5000",
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			IdleTimeout:  time.Minute,
+			MaxBodyBytes: 4096,
+		},
+		TLS: TLS{
+			ReloadInterval: 10 * time.Second,
+			ClientAuth:     "none",
+			HSTSMaxAge:     180 * 24 * time.Hour,
+		},
+		Database: Database{
+			DSN:             "root:root@/go_practice?parseTime=true",
+			QueryTimeout:    5 * time.Second,
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 5 * time.Minute,
+
+			ReplicaCheckInterval: 5 * time.Second,
+			ReadYourWrites:       5 * time.Second,
+		},
+		Session: Session{
+			Lifetime:     12 * time.Hour,
+			CookieSecure: true,
+		},
+		Lockout: Lockout{
+			// Left empty so Load can pick memory for the memory dsn
+			Store:            "",
+			AccountThreshold: 5,
+			IPThreshold:      20,
+			BaseDelay:        30 * time.Second,
+			MaxDelay:         time.Hour,
+			Window:           24 * time.Hour,
+		},
+		Deletion: Deletion{
+			Grace:         30 * 24 * time.Hour,
+			Policy:        "anonymise",
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Passwords: Passwords{
+			Algorithm:         "argon2id",
+			Argon2Memory:      64 * 1024,
+			Argon2Iterations:  3,
+			Argon2Parallelism: 2,
+			BcryptCost:        12,
+		},
+		CORS: CORS{
+			Methods:     []string{"GET", "POST", "DELETE"},
+			Headers:     []string{"Content-Type", "X-CSRF-Token", "Authorization"},
+			Expose:      []string{"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "X-Request-ID"},
+			Credentials: true,
+			MaxAge:      10 * time.Minute,
+		},
+		RateLimit: RateLimit{
+			Store: "memory",
+			IP:    120,
+			Auth:  10,
+			User:  300,
+		},
+		Metrics: Metrics{Addr: "localhost:5001"},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Log: Log{
+			Format: "json",
+			Level:  "info",
+		},
+		Health: Health{ReadinessTimeout: 2 * time.Second},
+		Shutdown: Shutdown{
+			Delay:   5 * time.Second,
+			Timeout: 20 * time.Second,
+		},
+	}
+}
+
+// Validate reports every invalid setting at once, each error names the
+// setting the way the config file does.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, setting, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+		}
+	}
+	oneOf := func(setting, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		check(false, setting, "must be one of %v, got %q", allowed, value)
+	}
+	address := func(setting, value string) {
+		_, _, err := net.SplitHostPort(value)
+		check(err == nil, setting, "must be host:port, got %q", value)
+	}
+
+	address("server.addr", c.Server.Addr)
+	check(c.Server.ReadTimeout > 0, "server.read_timeout", "must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout", "must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout", "must be positive")
+	check(c.Server.MaxBodyBytes > 0, "server.max_body_bytes", "must be positive")
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls", "cert_file and key_file must be set together")
+	check(!c.TLS.Dev || c.TLS.CertFile == "", "tls.dev", "can't be combined with cert_file")
+	check(c.TLS.ReloadInterval > 0, "tls.reload_interval", "must be positive")
+	oneOf("tls.client_auth", c.TLS.ClientAuth, "none", "optional", "require")
+	check(c.TLS.ClientAuth == "none" || c.TLS.ClientCAFile != "", "tls.client_ca_file", "must be set when client_auth is %s", c.TLS.ClientAuth)
+	check(c.TLS.ClientAuth == "none" || c.TLS.Enabled(), "tls.client_auth", "needs TLS to be enabled")
+	check(c.TLS.HSTSMaxAge >= 0, "tls.hsts_max_age", "must not be negative")
+	if c.TLS.RedirectAddr != "" {
+		address("tls.redirect_addr", c.TLS.RedirectAddr)
+		check(c.TLS.Enabled(), "tls.redirect_addr", "needs TLS to be enabled")
+	}
+
+	check(c.Database.DSN != "", "database.dsn", "must be set")
+	check(c.Database.QueryTimeout >= 0, "database.query_timeout", "must not be negative")
+	check(c.Database.MaxOpenConns >= 0, "database.max_open_conns", "must not be negative")
+	check(c.Database.MaxIdleConns >= 0, "database.max_idle_conns", "must not be negative")
+	check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns, "database.max_idle_conns", "must not exceed max_open_conns")
+	check(c.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime", "must not be negative")
+	if len(c.Database.Replicas) > 0 {
+		check(c.Database.DSN != "memory" && !strings.HasPrefix(c.Database.DSN, "sqlite:"), "database.replicas", "need a MySQL or Postgres dsn")
+		check(c.Database.ReplicaCheckInterval > 0, "database.replica_check_interval", "must be positive")
+		check(c.Database.ReadYourWrites >= 0, "database.read_your_writes", "must not be negative")
+	}
+	if c.Database.DSN == "memory" {
+		check(c.Lockout.Store == "memory", "lockout.store", "must be memory when the dsn is memory")
+		check(c.RateLimit.Store == "memory", "rate_limit.store", "must be memory when the dsn is memory")
+	}
+
+	check(c.Session.Lifetime > 0, "session.lifetime", "must be positive")
+
+	// mysql is what database was called before other databases were supported
+	oneOf("lockout.store", c.Lockout.Store, "database", "mysql", "memory")
+	check(c.Lockout.AccountThreshold > 0, "lockout.account_threshold", "must be positive")
+	check(c.Lockout.IPThreshold > 0, "lockout.ip_threshold", "must be positive")
+	check(c.Lockout.BaseDelay > 0, "lockout.base_delay", "must be positive")
+	check(c.Lockout.MaxDelay >= c.Lockout.BaseDelay, "lockout.max_delay", "must not be less than base_delay")
+	check(c.Lockout.Window >= 0, "lockout.window", "must not be negative")
+
+	check(c.Deletion.Grace >= 0, "deletion.grace", "must not be negative")
+	check(c.Deletion.Retention >= 0, "deletion.retention", "must not be negative")
+	check(c.Deletion.PurgeInterval > 0, "deletion.purge_interval", "must be positive")
+	oneOf("deletion.policy", c.Deletion.Policy, "anonymise", "delete")
+
+	oneOf("passwords.algorithm", c.Passwords.Algorithm, "argon2id", "bcrypt")
+	check(c.Passwords.Argon2Iterations > 0, "passwords.argon2_iterations", "must be positive")
+	check(c.Passwords.Argon2Parallelism > 0, "passwords.argon2_parallelism", "must be positive")
+	check(c.Passwords.Argon2Memory >= 8*uint32(c.Passwords.Argon2Parallelism), "passwords.argon2_memory", "must be at least 8 KiB per lane")
+	check(c.Passwords.BcryptCost >= 4 && c.Passwords.BcryptCost <= 31, "passwords.bcrypt_cost", "must be between 4 and 31, got %d", c.Passwords.BcryptCost)
+
+	check(!c.CORS.Credentials || !slices.Contains(c.CORS.Origins, "*"), "cors.origins", "must not contain * when cors.credentials is set, list the origins instead")
+	check(c.CORS.MaxAge >= 0, "cors.max_age", "must not be negative")
+
+	oneOf("rate_limit.store", c.RateLimit.Store, "memory", "database", "mysql")
+	check(c.RateLimit.IP > 0, "rate_limit.ip", "must be positive")
+	check(c.RateLimit.Auth > 0, "rate_limit.auth", "must be positive")
+	check(c.RateLimit.User > 0, "rate_limit.user", "must be positive")
+
+	if c.Metrics.Addr != "" {
+		address("metrics.addr", c.Metrics.Addr)
+		check(c.Metrics.Addr != c.Server.Addr, "metrics.addr", "must differ from server.addr")
+	}
+	check(c.Metrics.AdminToken == "" || len(c.Metrics.AdminToken) >= 32, "metrics.admin_token", "must be at least 32 characters")
+	check(c.Metrics.AdminToken == "" || c.Metrics.Addr != "", "metrics.admin_token", "needs metrics.addr to be set")
+
+	oneOf("tracing.exporter", c.Tracing.Exporter, "none", "stdout", "otlp")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+
+	oneOf("log.format", c.Log.Format, "json", "text")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+
+	check(c.Health.ReadinessTimeout > 0, "health.readiness_timeout", "must be positive")
+
+	check(c.Shutdown.Delay >= 0, "shutdown.delay", "must not be negative")
+	check(c.Shutdown.Timeout > 0, "shutdown.timeout", "must be positive")
+
+	return errors.Join(errs...)
 }
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the name of every environment variable read by Load.
+const EnvPrefix = "PRACTICE_"
+
+// setting is one leaf of Config together with the names it goes by.
+type setting struct {
+	index  []int
+	path   string
+	flag   string
+	env    string
+	usage  string
+	secret bool
+}
+
+// settings lists the leaves of Config in declaration order.
+func settings() []setting {
+	var list []setting
+	var walk func(t reflect.Type, index []int, path string)
+	walk = func(t reflect.Type, index []int, path string) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			fieldIndex := append(append([]int{}, index...), i)
+			fieldPath := path + field.Tag.Get("yaml")
+			if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+				walk(field.Type, fieldIndex, fieldPath+".")
+				continue
+			}
+			name := field.Tag.Get("flag")
+			list = append(list, setting{
+				index:  fieldIndex,
+				path:   fieldPath,
+				flag:   name,
+				env:    EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_")),
+				usage:  field.Tag.Get("usage"),
+				secret: field.Tag.Get("secret") == "true",
+			})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), nil, "")
+	return list
+}
+
+// Load builds the config from its defaults, the YAML file named by -config or
+// PRACTICE_CONFIG, environment variables and finally the command-line flags,
+// each overriding the last, and validates the result.
+//
+// Flags for every setting plus -config are defined on fs, so callers can add
+// their own flags to fs before calling Load.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	list := settings()
+
+	// Flags are parsed into their own copy so they can be applied last,
+	// after the file and environment they're meant to override
+	flagged := Default()
+	file := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "YAML config file, see pkg/config for the settings")
+	for _, s := range list {
+		fs.Var(value{reflect.ValueOf(&flagged).Elem().FieldByIndex(s.index)}, s.flag, s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if *file != "" {
+		if err := cfg.loadFile(*file); err != nil {
+			return nil, err
+		}
+	}
+
+	target := reflect.ValueOf(&cfg).Elem()
+	for _, s := range list {
+		raw, ok := os.LookupEnv(s.env)
+		if !ok {
+			continue
+		}
+		if err := (value{target.FieldByIndex(s.index)}).Set(raw); err != nil {
+			return nil, fmt.Errorf("config: %s (%s): %w", s.env, s.path, err)
+		}
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	source := reflect.ValueOf(&flagged).Elem()
+	for _, s := range list {
+		if set[s.flag] {
+			target.FieldByIndex(s.index).Set(source.FieldByIndex(s.index))
+		}
+	}
+
+	// The memory dsn has no database to keep the failed logins in
+	if cfg.Lockout.Store == "" {
+		cfg.Lockout.Store = "database"
+		if cfg.Database.DSN == "memory" {
+			cfg.Lockout.Store = "memory"
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config: invalid settings:\n%w", err)
+	}
+	return &cfg, nil
+}
+
+// loadFile applies the settings in a YAML file on top of c. Unknown keys are
+// an error so a typo doesn't silently leave the default in place.
+func (c *Config) loadFile(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config: %s: %w", name, err)
+	}
+	return nil
+}
+
+// Redacted returns a copy of c with every secret setting replaced, for
+// printing or logging.
+func (c *Config) Redacted() Config {
+	redacted := *c
+	target := reflect.ValueOf(&redacted).Elem()
+	for _, s := range settings() {
+		field := target.FieldByIndex(s.index)
+		if !s.secret || field.IsZero() {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString("[redacted]")
+		case reflect.Slice:
+			// A new slice, the original still shares its array with c
+			hidden := make([]string, field.Len())
+			for i := range hidden {
+				hidden[i] = "[redacted]"
+			}
+			field.Set(reflect.ValueOf(hidden))
+		}
+	}
+	return redacted
+}
+
+// Print writes the config as YAML with secrets redacted, in the same format
+// Load reads.
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// value adapts a Config field to flag.Value, it also parses environment
+// variables so both take the same syntax.
+type value struct {
+	v reflect.Value
+}
+
+func (v value) String() string {
+	// flag creates zero Values to work out whether a default is worth printing
+	if !v.v.IsValid() {
+		return ""
+	}
+
+	switch field := v.v.Interface().(type) {
+	case time.Duration:
+		return field.String()
+	case []string:
+		return strings.Join(field, ",")
+	default:
+		return fmt.Sprint(field)
+	}
+}
+
+func (v value) Set(raw string) error {
+	if v.v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.v.Kind() {
+	case reflect.String:
+		v.v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 0, v.v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.v.SetInt(n)
+	case reflect.Uint8, reflect.Uint32:
+		n, err := strconv.ParseUint(raw, 0, v.v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.v.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.v.SetFloat(f)
+	case reflect.Slice:
+		// Lists are comma separated, an empty value clears the list
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.v.Type())
+	}
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as a bare -flag.
+func (v value) IsBoolFlag() bool {
+	return v.v.IsValid() && v.v.Kind() == reflect.Bool
+}
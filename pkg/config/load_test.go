@@ -0,0 +1,58 @@
+package config
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestLoadLockoutStoreDefault(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "database"},
+		{[]string{"-dsn", "memory"}, "memory"},
+		{[]string{"-dsn", "sqlite:app.db"}, "database"},
+		{[]string{"-dsn", "sqlite:app.db", "-lockout-store", "memory"}, "memory"},
+	}
+
+	for _, tt := range tests {
+		cfg, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), tt.args)
+		if err != nil {
+			t.Errorf("Load(%q): %v", tt.args, err)
+			continue
+		}
+		if cfg.Lockout.Store != tt.want {
+			t.Errorf("Load(%q) lockout.store = %q, want %q", tt.args, cfg.Lockout.Store, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsDatabaseStoresWithMemoryDSN(t *testing.T) {
+	_, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-dsn", "memory", "-lockout-store", "database"})
+	if err == nil || !strings.Contains(err.Error(), "lockout.store") {
+		t.Errorf("Load() = %v, want a lockout.store error", err)
+	}
+}
+
+func TestLoadAdminToken(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-admin-token", "0123456789abcdef0123456789abcdef"}, ""},
+		{[]string{"-admin-token", "short"}, "metrics.admin_token"},
+		{[]string{"-admin-token", "0123456789abcdef0123456789abcdef", "-metrics-addr", ""}, "metrics.admin_token"},
+	}
+
+	for _, tt := range tests {
+		_, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), tt.args)
+		if tt.want == "" && err != nil {
+			t.Errorf("Load(%q): %v", tt.args, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("Load(%q) = %v, want a %s error", tt.args, err, tt.want)
+		}
+	}
+}
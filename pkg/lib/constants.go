@@ -2,3 +2,9 @@ package lib
 
 var InternalServerError = Response{Status: false, Result: nil, Message: "Internal Server Error"}
 var MethodNotAllowed = Response{Status: false, Result: nil, Message: "Method Not Allowed"}
+var TooManyRequests = Response{Status: false, Result: nil, Message: "Too Many Requests"}
+var Unauthorized = Response{Status: false, Result: nil, Message: "Unauthorized"}
+var Forbidden = Response{Status: false, Result: nil, Message: "Forbidden"}
+var InvalidCSRFToken = Response{Status: false, Result: nil, Message: "Invalid CSRF Token"}
+var ServiceUnavailable = Response{Status: false, Result: nil, Message: "Service Unavailable"}
+var GatewayTimeout = Response{Status: false, Result: nil, Message: "Gateway Timeout"}